@@ -0,0 +1,181 @@
+package nicklog
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const cDefAsyncBufSize = 1024
+
+type OverflowPolicy int32
+
+const (
+	// OverflowBlock makes the caller wait for free space in the buffer.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop silently drops entries that don't fit.
+	OverflowDrop
+	// OverflowCount drops entries and writes "N log entries dropped" once the buffer drains.
+	OverflowCount
+)
+
+type asyncEntry struct {
+	entry Entry
+	buf   *[]byte
+	flush chan struct{}
+}
+
+// asyncWriter is a bounded queue between the callers and the file.
+// A single goroutine takes entries from the queue and writes them with Logger.write.
+type asyncWriter struct {
+	l        *Logger
+	ch       chan asyncEntry
+	overflow OverflowPolicy
+	dropped  uint64
+	reported uint64
+	lock     sync.RWMutex
+	closed   bool
+	done     chan struct{}
+}
+
+func newAsyncWriter(l *Logger, size int, overflow OverflowPolicy) *asyncWriter {
+
+	if size <= 0 {
+		size = cDefAsyncBufSize
+	}
+
+	w := &asyncWriter{
+		l:        l,
+		ch:       make(chan asyncEntry, size),
+		overflow: overflow,
+		done:     make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w
+}
+
+// push takes ownership of buf. It returns false if the writer is closed,
+// then the caller still owns buf. The queued entry has no Fields, their
+// values may be changed by the caller before the entry is written.
+func (w *asyncWriter) push(e *Entry, buf *[]byte) bool {
+
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	if w.closed {
+		return false
+	}
+
+	ae := asyncEntry{entry: *e, buf: buf}
+	ae.entry.Fields = nil
+
+	if w.overflow == OverflowBlock {
+		w.ch <- ae
+		return true
+	}
+
+	select {
+	case w.ch <- ae:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+		putBuf(buf)
+	}
+
+	return true
+}
+
+// flush waits until everything queued before the call is written.
+func (w *asyncWriter) flush() {
+
+	w.lock.RLock()
+	if w.closed {
+		w.lock.RUnlock()
+		return
+	}
+	done := make(chan struct{})
+	w.ch <- asyncEntry{flush: done}
+	w.lock.RUnlock()
+
+	<-done
+}
+
+// close drains the queue and stops the goroutine.
+func (w *asyncWriter) close() {
+
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.ch)
+	w.lock.Unlock()
+
+	<-w.done
+}
+
+func (w *asyncWriter) run() {
+
+	defer close(w.done)
+
+	for e := range w.ch {
+		if e.flush != nil {
+			w.reportDropped()
+			close(e.flush)
+			continue
+		}
+
+		e.entry.Line = *e.buf
+		w.l.write(&e.entry)
+		putBuf(e.buf)
+
+		if len(w.ch) == 0 {
+			w.reportDropped()
+		}
+	}
+
+	w.reportDropped()
+}
+
+func (w *asyncWriter) reportDropped() {
+
+	if w.overflow != OverflowCount {
+		return
+	}
+
+	dropped := atomic.LoadUint64(&w.dropped)
+	if dropped == w.reported {
+		return
+	}
+
+	e := Entry{
+		Time:   time.Now(),
+		Level:  LevelWarn,
+		Msg:    "log entries dropped",
+		Fields: []Field{Uint64("count", dropped-w.reported)},
+	}
+	w.reported = dropped
+
+	buf := getBuf()
+	*buf = appendEntry(*buf, w.l.GetFormat(), &e)
+	e.Line = *buf
+	w.l.write(&e)
+	putBuf(buf)
+}
+
+// Flush waits until all entries buffered in async mode are written to the file.
+func (l *Logger) Flush() {
+	if l.async != nil {
+		l.async.flush()
+	}
+}
+
+// Dropped returns the number of entries dropped because the async buffer was full.
+func (l *Logger) Dropped() uint64 {
+	if l.async == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&l.async.dropped)
+}
@@ -0,0 +1,125 @@
+package nicklog
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// cStackDepth is enough for the caller, stack traces grow beyond it.
+const cStackDepth = 64
+
+// SetCaller turns on the "caller=dir/file.go:line" annotation of entries.
+// Raw writes (Print, Println, Printf) are not annotated.
+func (l *Logger) SetCaller(enabled bool) {
+	atomic.StoreInt32(&l.addCaller, boolInt32(enabled))
+}
+
+// SetStack turns on the stack trace of Error and Fatal entries. It is written
+// to the file and to the mail batch on indented lines after the entry.
+func (l *Logger) SetStack(enabled bool) {
+	atomic.StoreInt32(&l.addStack, boolInt32(enabled))
+}
+
+// WithCallerSkip returns a child logger that skips skip more frames when
+// looking for the caller, for wrapper libraries around Logger.
+func (l *Logger) WithCallerSkip(skip int) *Logger {
+	return &Logger{
+		logCore:    l.logCore,
+		fields:     l.fields,
+		callerSkip: l.callerSkip + skip,
+		sampleKey:  l.sampleKey,
+	}
+}
+
+func boolInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// annotate sets e.Caller and e.Stack. skip is the number of frames between
+// the caller of annotate and the user code, pc is used instead when set (slog).
+func (l *Logger) annotate(e *Entry, skip int, pc uintptr) {
+
+	addCaller := atomic.LoadInt32(&l.addCaller) != 0
+	addStack := e.Level >= LevelError && atomic.LoadInt32(&l.addStack) != 0
+
+	if !addCaller && !addStack {
+		return
+	}
+
+	// r.PC of slog already points at the user code
+	if pc == 0 {
+		skip += l.callerSkip
+	}
+
+	pcs := make([]uintptr, cStackDepth)
+	var n int
+	for {
+		// 2 is runtime.Callers and annotate
+		n = runtime.Callers(skip+2, pcs)
+		if n < len(pcs) || !addStack {
+			break
+		}
+		pcs = make([]uintptr, 2*len(pcs))
+	}
+	stack := pcs[:n]
+
+	if pc != 0 {
+		stack = nil
+		for i := 0; i < n; i++ {
+			if pcs[i] == pc {
+				stack = pcs[i:n]
+				break
+			}
+		}
+		if stack == nil {
+			stack = []uintptr{pc}
+		}
+	}
+
+	if len(stack) == 0 {
+		return
+	}
+
+	frames := runtime.CallersFrames(stack)
+	frame, more := frames.Next()
+
+	if addCaller {
+		e.Caller = shortPath(frame.File) + ":" + strconv.Itoa(frame.Line)
+	}
+
+	if !addStack {
+		return
+	}
+
+	var b strings.Builder
+	for {
+		b.WriteString(frame.Function)
+		b.WriteString("\n\t")
+		b.WriteString(frame.File)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(frame.Line))
+		b.WriteByte('\n')
+		if !more {
+			break
+		}
+		frame, more = frames.Next()
+	}
+	e.Stack = b.String()
+}
+
+// shortPath keeps the package directory and the file name.
+func shortPath(file string) string {
+	i := strings.LastIndexByte(file, '/')
+	if i <= 0 {
+		return file
+	}
+	if j := strings.LastIndexByte(file[:i], '/'); j >= 0 {
+		return file[j+1:]
+	}
+	return file
+}
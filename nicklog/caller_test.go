@@ -0,0 +1,45 @@
+package nicklog
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func deepError(l *Logger, depth int) {
+	if depth > 0 {
+		deepError(l, depth-1)
+		return
+	}
+	l.Error("deep")
+}
+
+func TestStackNotTruncated(t *testing.T) {
+
+	dir := t.TempDir()
+	l, err := NewLoggerFromConfig(Config{
+		Dir:      dir,
+		FileName: "app.log",
+		MaxSize:  1024,
+		MaxFiles: 2,
+		AddStack: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deepError(l, 150)
+	l.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, "app.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte("nicklog.deepError\n")); n != 151 {
+		t.Errorf("got %d deepError frames, want 151", n)
+	}
+	if !bytes.Contains(data, []byte("TestStackNotTruncated")) {
+		t.Error("stack is cut before the test function")
+	}
+}
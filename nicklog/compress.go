@@ -0,0 +1,140 @@
+package nicklog
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	cGzipExt = ".gz"
+	cTmpExt  = ".tmp"
+)
+
+type Compression int32
+
+const (
+	CompressNone Compression = iota
+	CompressGzip
+)
+
+// SetCompression enables compression of rotated files. Compression runs
+// in a background goroutine, Write only signals it after a rotation.
+func (l *Logger) SetCompression(c Compression) {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	atomic.StoreInt32(&l.compress, int32(c))
+	if c != CompressNone {
+		l.compressOnce.Do(func() {
+			l.compressCh = make(chan struct{}, 1)
+			l.compressDone = make(chan struct{})
+			go l.compressWorker(l.compressCh)
+		})
+		l.signalCompress()
+	}
+}
+
+func (l *Logger) GetCompression() Compression {
+	return Compression(atomic.LoadInt32(&l.compress))
+}
+
+// signalCompress never blocks, a pending signal already covers all files.
+// It is called under l.lock, Close closes the channel.
+func (l *Logger) signalCompress() {
+	if l.compressCh == nil || l.GetCompression() == CompressNone {
+		return
+	}
+	select {
+	case l.compressCh <- struct{}{}:
+	default:
+	}
+}
+
+// compressWorker stops when Close closes ch.
+func (l *Logger) compressWorker(ch chan struct{}) {
+	defer close(l.compressDone)
+	for range ch {
+		l.compressArchives()
+	}
+}
+
+// compressArchives compresses every plain archive found in arcDir,
+// so files left by a crash or a full queue are picked up too.
+func (l *Logger) compressArchives() {
+
+	files, err := ioutil.ReadDir(l.arcDir)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	for i := 0; i < len(files); i++ {
+		fileName := files[i].Name()
+		if files[i].IsDir() || strings.HasSuffix(fileName, cGzipExt) {
+			continue
+		}
+		if _, ok := l.parseArcName(fileName); !ok {
+			continue
+		}
+		if err := gzipFile(filepath.Join(l.arcDir, fileName), &l.arcLock); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// gzipFile writes fileName.gz next to fileName and removes the original.
+// The swap is done under lock, so that an archive deleted by the retention
+// meanwhile doesn't come back compressed.
+func gzipFile(fileName string, lock sync.Locker) (err error) {
+
+	src, err := os.Open(fileName)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	tmpName := fileName + cGzipExt + cTmpExt
+	dst, err := os.Create(tmpName)
+	if err != nil {
+		return
+	}
+
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(fileName)
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err = os.Stat(fileName); err != nil {
+		os.Remove(tmpName)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return
+	}
+
+	if err = os.Rename(tmpName, fileName+cGzipExt); err != nil {
+		os.Remove(tmpName)
+		return
+	}
+
+	return os.Remove(fileName)
+}
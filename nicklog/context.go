@@ -0,0 +1,55 @@
+package nicklog
+
+import (
+	"context"
+)
+
+type ctxFieldsKey struct{}
+
+// With returns a child logger that adds fields to every entry. The child shares
+// the file, rotation, sinks and mail senders of l, closing either closes both.
+// Raw writes (Print, Println, Printf) in the text format get the fields as
+// " key=value" at the end.
+func (l *Logger) With(fields ...Field) *Logger {
+
+	if len(fields) == 0 {
+		return l
+	}
+
+	return &Logger{
+		logCore:    l.logCore,
+		fields:     appendFields(l.fields, fields),
+		callerSkip: l.callerSkip,
+		sampleKey:  l.sampleKey,
+	}
+}
+
+// WithContext is With for the fields stored in ctx by NewContext.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	return l.With(FieldsFromContext(ctx)...)
+}
+
+// NewContext returns a copy of ctx carrying fields in addition to those
+// already in ctx, e.g. a request id set by an HTTP middleware.
+func NewContext(ctx context.Context, fields ...Field) context.Context {
+	return context.WithValue(ctx, ctxFieldsKey{}, appendFields(FieldsFromContext(ctx), fields))
+}
+
+func FieldsFromContext(ctx context.Context) []Field {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(ctxFieldsKey{}).([]Field)
+	return fields
+}
+
+// appendFields never writes into a, it may be shared by other loggers.
+func appendFields(a []Field, b []Field) []Field {
+	if len(b) == 0 {
+		return a
+	}
+	if len(a) == 0 {
+		return append([]Field(nil), b...)
+	}
+	return append(a[:len(a):len(a)], b...)
+}
@@ -0,0 +1,288 @@
+package nicklog
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"nicklib/bytesconv"
+)
+
+const cJSONTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+type Format int32
+
+const (
+	FormatText Format = iota
+	FormatJSON
+)
+
+// Entry is a single log record before encoding.
+type Entry struct {
+	Time   time.Time
+	Level  Level
+	Msg    string
+	Fields []Field
+
+	// Caller is "dir/file.go:line" and Stack the stack trace, when enabled.
+	Caller string
+	Stack  string
+
+	// Line is the encoded entry as written to the file.
+	// It is only valid during Sink.WriteEntry.
+	Line []byte
+}
+
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 256)
+		return &b
+	},
+}
+
+func getBuf() *[]byte {
+	return bufPool.Get().(*[]byte)
+}
+
+func putBuf(b *[]byte) {
+	// don't keep huge buffers in the pool
+	if cap(*b) > 64*1024 {
+		return
+	}
+	*b = (*b)[:0]
+	bufPool.Put(b)
+}
+
+// SetFormat switches the encoding of entries. With FormatJSON raw writes
+// (Print, Println, Printf) are written as INFO objects too.
+func (l *Logger) SetFormat(format Format) {
+	atomic.StoreInt32(&l.format, int32(format))
+}
+
+func (l *Logger) GetFormat() Format {
+	return Format(atomic.LoadInt32(&l.format))
+}
+
+func appendEntry(dst []byte, format Format, e *Entry) []byte {
+	if format == FormatJSON {
+		return appendJSONEntry(dst, e)
+	}
+	return appendTextEntry(dst, e)
+}
+
+// appendTextEntry writes "2006-01-02 15:04:05 LEVEL msg key=value ...\n".
+func appendTextEntry(dst []byte, e *Entry) []byte {
+
+	dst = e.Time.AppendFormat(dst, cLineTimeFormat)
+	dst = append(dst, ' ')
+	dst = append(dst, e.Level.String()...)
+	dst = append(dst, ' ')
+
+	msg := e.Msg
+	for len(msg) > 0 && msg[len(msg)-1] == '\n' {
+		msg = msg[:len(msg)-1]
+	}
+	dst = append(dst, msg...)
+
+	for i := 0; i < len(e.Fields); i++ {
+		dst = append(dst, ' ')
+		dst = append(dst, e.Fields[i].Key...)
+		dst = append(dst, '=')
+		dst = appendTextValue(dst, &e.Fields[i])
+	}
+
+	if len(e.Caller) > 0 {
+		dst = append(dst, " caller="...)
+		dst = append(dst, e.Caller...)
+	}
+	dst = append(dst, '\n')
+
+	// indented, so that the lines never start with a timestamp
+	if len(e.Stack) > 0 {
+		stack := e.Stack
+		for len(stack) > 0 {
+			i := strings.IndexByte(stack, '\n')
+			if i < 0 {
+				i = len(stack) - 1
+			}
+			dst = append(dst, '\t')
+			dst = append(dst, stack[:i+1]...)
+			stack = stack[i+1:]
+		}
+		if dst[len(dst)-1] != '\n' {
+			dst = append(dst, '\n')
+		}
+	}
+
+	return dst
+}
+
+func appendTextValue(dst []byte, f *Field) []byte {
+
+	switch f.typ {
+	case fieldString:
+		return appendTextString(dst, f.str)
+	case fieldInt:
+		return bytesconv.FormatInt64(f.num, dst)
+	case fieldUint:
+		return bytesconv.FormatUint64(uint64(f.num), dst)
+	case fieldFloat:
+		return appendFloat(dst, f.fnum)
+	case fieldBool:
+		return strconv.AppendBool(dst, f.num != 0)
+	case fieldDuration:
+		return append(dst, time.Duration(f.num).String()...)
+	case fieldTime:
+		return f.tm.AppendFormat(dst, cJSONTimeFormat)
+	case fieldError:
+		if f.any == nil {
+			return append(dst, "<nil>"...)
+		}
+		return appendTextString(dst, f.any.(error).Error())
+	default:
+		return appendTextString(dst, fmt.Sprint(f.any))
+	}
+}
+
+// appendTextString quotes the value only if it can't be read back unambiguously.
+func appendTextString(dst []byte, s string) []byte {
+	if len(s) == 0 {
+		return append(dst, `""`...)
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c <= ' ' || c == '"' || c == '=' || c == '\\' || c >= utf8.RuneSelf {
+			return strconv.AppendQuote(dst, s)
+		}
+	}
+	return append(dst, s...)
+}
+
+// appendJSONEntry writes a single JSON object followed by a newline.
+func appendJSONEntry(dst []byte, e *Entry) []byte {
+
+	dst = append(dst, `{"time":"`...)
+	dst = e.Time.AppendFormat(dst, cJSONTimeFormat)
+	dst = append(dst, `","level":"`...)
+	dst = append(dst, e.Level.String()...)
+	dst = append(dst, `","msg":`...)
+
+	msg := e.Msg
+	for len(msg) > 0 && msg[len(msg)-1] == '\n' {
+		msg = msg[:len(msg)-1]
+	}
+	dst = appendJSONString(dst, msg)
+
+	for i := 0; i < len(e.Fields); i++ {
+		dst = append(dst, ',')
+		dst = appendJSONString(dst, e.Fields[i].Key)
+		dst = append(dst, ':')
+		dst = appendJSONValue(dst, &e.Fields[i])
+	}
+
+	if len(e.Caller) > 0 {
+		dst = append(dst, `,"caller":`...)
+		dst = appendJSONString(dst, e.Caller)
+	}
+
+	if len(e.Stack) > 0 {
+		dst = append(dst, `,"stack":`...)
+		dst = appendJSONString(dst, e.Stack)
+	}
+
+	return append(dst, '}', '\n')
+}
+
+func appendJSONValue(dst []byte, f *Field) []byte {
+
+	switch f.typ {
+	case fieldString:
+		return appendJSONString(dst, f.str)
+	case fieldInt:
+		return bytesconv.FormatInt64(f.num, dst)
+	case fieldUint:
+		return bytesconv.FormatUint64(uint64(f.num), dst)
+	case fieldFloat:
+		if math.IsNaN(f.fnum) || math.IsInf(f.fnum, 0) {
+			dst = append(dst, '"')
+			dst = strconv.AppendFloat(dst, f.fnum, 'g', -1, 64)
+			return append(dst, '"')
+		}
+		return appendFloat(dst, f.fnum)
+	case fieldBool:
+		return strconv.AppendBool(dst, f.num != 0)
+	case fieldDuration:
+		return appendJSONString(dst, time.Duration(f.num).String())
+	case fieldTime:
+		dst = append(dst, '"')
+		dst = f.tm.AppendFormat(dst, cJSONTimeFormat)
+		return append(dst, '"')
+	case fieldError:
+		if f.any == nil {
+			return append(dst, "null"...)
+		}
+		return appendJSONString(dst, f.any.(error).Error())
+	default:
+		b, err := json.Marshal(f.any)
+		if err != nil {
+			return appendJSONString(dst, fmt.Sprint(f.any))
+		}
+		return append(dst, b...)
+	}
+}
+
+// appendFloat writes the shortest representation that reads back as the same
+// value, e.g. 1.1 and 1e-07, valid in JSON for every finite value.
+func appendFloat(dst []byte, val float64) []byte {
+	return strconv.AppendFloat(dst, val, 'g', -1, 64)
+}
+
+func appendJSONString(dst []byte, s string) []byte {
+
+	dst = append(dst, '"')
+
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				dst = append(dst, '\\', c)
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', bytesconv.HexCharUpper(c>>4), bytesconv.HexCharUpper(c&0xF))
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+
+	return append(dst, '"')
+}
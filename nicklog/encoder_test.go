@@ -0,0 +1,55 @@
+package nicklog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRawWriteJSON(t *testing.T) {
+
+	dir := t.TempDir()
+	l, err := NewLoggerFromConfig(Config{
+		Dir:      dir,
+		FileName: "app.log",
+		MaxSize:  1024,
+		MaxFiles: 2,
+		Format:   FormatJSON,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Println("println", 1)
+	l.Print("print")
+	l.Printf("printf %d\n", 2)
+	l.With(String("req", "r1")).Println("child")
+	l.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, "app.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"println 1", "print", "printf 2", "child"}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want), data)
+	}
+
+	for i := 0; i < len(lines); i++ {
+		var e map[string]interface{}
+		if err := json.Unmarshal(lines[i], &e); err != nil {
+			t.Fatalf("line %q: %v", lines[i], err)
+		}
+		if e["level"] != "INFO" || e["msg"] != want[i] || e["time"] == nil {
+			t.Errorf("line %q", lines[i])
+		}
+	}
+
+	if !bytes.Contains(lines[3], []byte(`"req":"r1"`)) {
+		t.Errorf("child fields missing: %s", lines[3])
+	}
+}
@@ -0,0 +1,77 @@
+package nicklog
+
+import (
+	"time"
+)
+
+type fieldType uint8
+
+const (
+	fieldString fieldType = iota
+	fieldInt
+	fieldUint
+	fieldFloat
+	fieldBool
+	fieldDuration
+	fieldTime
+	fieldError
+	fieldAny
+)
+
+// Field is a typed key/value pair attached to an entry. Use the constructors
+// below, the zero Field is an empty string value.
+type Field struct {
+	Key  string
+	typ  fieldType
+	num  int64
+	fnum float64
+	str  string
+	tm   time.Time
+	any  interface{}
+}
+
+func String(key string, val string) Field {
+	return Field{Key: key, typ: fieldString, str: val}
+}
+
+func Int(key string, val int) Field {
+	return Field{Key: key, typ: fieldInt, num: int64(val)}
+}
+
+func Int64(key string, val int64) Field {
+	return Field{Key: key, typ: fieldInt, num: val}
+}
+
+func Uint64(key string, val uint64) Field {
+	return Field{Key: key, typ: fieldUint, num: int64(val)}
+}
+
+func Float64(key string, val float64) Field {
+	return Field{Key: key, typ: fieldFloat, fnum: val}
+}
+
+func Bool(key string, val bool) Field {
+	f := Field{Key: key, typ: fieldBool}
+	if val {
+		f.num = 1
+	}
+	return f
+}
+
+func Duration(key string, val time.Duration) Field {
+	return Field{Key: key, typ: fieldDuration, num: int64(val)}
+}
+
+func Time(key string, val time.Time) Field {
+	return Field{Key: key, typ: fieldTime, tm: val}
+}
+
+// Err stores err under the "error" key, a nil error is written as null.
+func Err(err error) Field {
+	return Field{Key: "error", typ: fieldError, any: err}
+}
+
+// Any stores val as is. It is formatted with fmt in text mode and encoding/json in JSON mode.
+func Any(key string, val interface{}) Field {
+	return Field{Key: key, typ: fieldAny, any: val}
+}
@@ -0,0 +1,226 @@
+package nicklog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+var levelNames = [...]string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+
+func (lv Level) String() string {
+	if lv < LevelDebug || lv > LevelFatal {
+		return "LEVEL(" + fmt.Sprint(int32(lv)) + ")"
+	}
+	return levelNames[lv]
+}
+
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "WARNING" {
+		s = "WARN"
+	}
+	for i := 0; i < len(levelNames); i++ {
+		if levelNames[i] == s {
+			return Level(i), nil
+		}
+	}
+	return LevelDebug, errors.New("Unknown level: " + s)
+}
+
+// levelToken matches only the names written by the encoders, unlike ParseLevel,
+// so that raw text like "error while reading" is not taken for a level.
+func levelToken(s string) (Level, bool) {
+	for i := 0; i < len(levelNames); i++ {
+		if levelNames[i] == s {
+			return Level(i), true
+		}
+	}
+	return LevelDebug, false
+}
+
+// SetMailLevel sets the minimum level of entries copied to the mail senders.
+// With mail routes it moves the lower bound of the lowest route.
+func (l *Logger) SetMailLevel(level Level) {
+	if len(l.mailSenders) > 0 {
+		l.mailSenders[0].SetLevel(level)
+	}
+}
+
+func (l *Logger) GetMailLevel() Level {
+	if len(l.mailSenders) > 0 {
+		return l.mailSenders[0].GetLevel()
+	}
+	return LevelDebug
+}
+
+// SetLevel sets the minimum level of entries written by Debug/Info/Warn/Error/Fatal.
+// It is safe to call while the logger is in use.
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+func (l *Logger) GetLevel() Level {
+	return Level(atomic.LoadInt32(&l.level))
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.GetLevel()
+}
+
+func (l *Logger) Debug(a ...interface{}) {
+	if l.Enabled(LevelDebug) {
+		l.output(LevelDebug, "", fmt.Sprintln(a...), nil)
+	}
+}
+
+func (l *Logger) Debugf(format string, a ...interface{}) {
+	if l.Enabled(LevelDebug) {
+		l.output(LevelDebug, format, fmt.Sprintf(format, a...), nil)
+	}
+}
+
+func (l *Logger) Info(a ...interface{}) {
+	if l.Enabled(LevelInfo) {
+		l.output(LevelInfo, "", fmt.Sprintln(a...), nil)
+	}
+}
+
+func (l *Logger) Infof(format string, a ...interface{}) {
+	if l.Enabled(LevelInfo) {
+		l.output(LevelInfo, format, fmt.Sprintf(format, a...), nil)
+	}
+}
+
+func (l *Logger) Warn(a ...interface{}) {
+	if l.Enabled(LevelWarn) {
+		l.output(LevelWarn, "", fmt.Sprintln(a...), nil)
+	}
+}
+
+func (l *Logger) Warnf(format string, a ...interface{}) {
+	if l.Enabled(LevelWarn) {
+		l.output(LevelWarn, format, fmt.Sprintf(format, a...), nil)
+	}
+}
+
+func (l *Logger) Error(a ...interface{}) {
+	if l.Enabled(LevelError) {
+		l.output(LevelError, "", fmt.Sprintln(a...), nil)
+	}
+}
+
+func (l *Logger) Errorf(format string, a ...interface{}) {
+	if l.Enabled(LevelError) {
+		l.output(LevelError, format, fmt.Sprintf(format, a...), nil)
+	}
+}
+
+// Fatal writes the entry at LevelFatal. Unlike log.Fatal it does not exit,
+// so the mail sender still gets a chance to deliver the alert.
+func (l *Logger) Fatal(a ...interface{}) {
+	if l.Enabled(LevelFatal) {
+		l.output(LevelFatal, "", fmt.Sprintln(a...), nil)
+	}
+}
+
+func (l *Logger) Fatalf(format string, a ...interface{}) {
+	if l.Enabled(LevelFatal) {
+		l.output(LevelFatal, format, fmt.Sprintf(format, a...), nil)
+	}
+}
+
+// Log writes msg with typed fields at the given level.
+func (l *Logger) Log(level Level, msg string, fields ...Field) {
+	if l.Enabled(level) {
+		l.output(level, msg, msg, fields)
+	}
+}
+
+func (l *Logger) Debugw(msg string, fields ...Field) {
+	if l.Enabled(LevelDebug) {
+		l.output(LevelDebug, msg, msg, fields)
+	}
+}
+
+func (l *Logger) Infow(msg string, fields ...Field) {
+	if l.Enabled(LevelInfo) {
+		l.output(LevelInfo, msg, msg, fields)
+	}
+}
+
+func (l *Logger) Warnw(msg string, fields ...Field) {
+	if l.Enabled(LevelWarn) {
+		l.output(LevelWarn, msg, msg, fields)
+	}
+}
+
+func (l *Logger) Errorw(msg string, fields ...Field) {
+	if l.Enabled(LevelError) {
+		l.output(LevelError, msg, msg, fields)
+	}
+}
+
+func (l *Logger) Fatalw(msg string, fields ...Field) {
+	if l.Enabled(LevelFatal) {
+		l.output(LevelFatal, msg, msg, fields)
+	}
+}
+
+// output writes an entry of a public method. key identifies similar entries
+// for sampling, it is the format or message template, "" means msg.
+func (l *Logger) output(level Level, key string, msg string, fields []Field) {
+
+	if len(key) == 0 {
+		key = strings.TrimRight(msg, "\n")
+	}
+	if !l.sample(level, key) {
+		return
+	}
+
+	e := Entry{
+		Time:   time.Now(),
+		Level:  level,
+		Msg:    msg,
+		Fields: fields,
+	}
+
+	// skip output and the public method
+	l.annotate(&e, 2, 0)
+
+	l.writeEntry(&e)
+}
+
+// writeEntry encodes the entry into a pooled buffer and writes it, or hands
+// the buffer over to the async queue. Rotation and the mail copy work exactly
+// as for Print/Println.
+func (l *Logger) writeEntry(e *Entry) {
+
+	if len(l.fields) > 0 {
+		e.Fields = appendFields(l.fields, e.Fields)
+	}
+
+	buf := getBuf()
+	*buf = appendEntry(*buf, l.GetFormat(), e)
+
+	if l.async != nil && l.async.push(e, buf) {
+		return
+	}
+
+	e.Line = *buf
+	l.write(e)
+	e.Line = nil
+	putBuf(buf)
+}
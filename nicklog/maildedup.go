@@ -0,0 +1,187 @@
+package nicklog
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const cMaxDupKeys = 1000
+
+// dupInfo collects repeats of one message within the current batch.
+type dupInfo struct {
+	line  []byte
+	count int
+	first time.Time
+	last  time.Time
+}
+
+// SetMaxPerHour limits the number of emails sent during any hour, 0 means no limit.
+// Batches over the limit are dropped and the next email says how many were lost.
+func (s *MailSender) SetMaxPerHour(n int) {
+	atomic.StoreInt64(&s.maxPerHour, int64(n))
+}
+
+// isDup reports whether e repeats a line already written to the current batch.
+// Lines are matched by their first line without the timestamp, so entries with
+// different fields are not repeats.
+func (s *MailSender) isDup(e *Entry) bool {
+
+	// the summary repeats the first line only, not a stack trace
+	line := e.Line
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+
+	rest, ok := cutTime(line)
+	if !ok {
+		return false
+	}
+	key := e.Level.String() + " " + string(rest)
+
+	s.dupLock.Lock()
+	defer s.dupLock.Unlock()
+
+	if d, ok := s.dups[key]; ok {
+		d.count++
+		d.last = e.Time
+		return true
+	}
+
+	if len(s.dups) >= cMaxDupKeys {
+		return false
+	}
+
+	d := &dupInfo{
+		line:  append([]byte(nil), line...),
+		count: 1,
+		first: e.Time,
+		last:  e.Time,
+	}
+	if s.dups == nil {
+		s.dups = make(map[string]*dupInfo)
+	}
+	s.dups[key] = d
+	s.dupOrder = append(s.dupOrder, d)
+
+	return false
+}
+
+// cutTime returns line after its timestamp, for text lines and JSON objects
+// starting with "time".
+func cutTime(line []byte) (rest []byte, ok bool) {
+
+	if bytes.HasPrefix(line, []byte(`{"time":"`)) {
+		rest = line[len(`{"time":"`):]
+		i := bytes.IndexByte(rest, '"')
+		if i < 0 {
+			return nil, false
+		}
+		return rest[i+1:], true
+	}
+
+	if len(line) <= len(cLineTimeFormat) {
+		return nil, false
+	}
+	if _, err := time.Parse(cLineTimeFormat, string(line[:len(cLineTimeFormat)])); err != nil {
+		return nil, false
+	}
+
+	return line[len(cLineTimeFormat):], true
+}
+
+// writeDups appends "line (xN between T1 and T2)" for every repeated line,
+// or for JSON the object with "repeat":N,"first":T1,"last":T2 added,
+// and resets the counters. It is called before the batch file is closed.
+func (s *MailSender) writeDups(w io.Writer) {
+
+	s.dupLock.Lock()
+	defer s.dupLock.Unlock()
+
+	var buf []byte
+	for i := 0; i < len(s.dupOrder); i++ {
+		d := s.dupOrder[i]
+		if d.count < 2 {
+			continue
+		}
+		if n := len(d.line); n > 0 && d.line[0] == '{' && d.line[n-1] == '}' {
+			buf = append(buf, d.line[:n-1]...)
+			buf = append(buf, `,"repeat":`...)
+			buf = strconv.AppendInt(buf, int64(d.count), 10)
+			buf = append(buf, `,"first":"`...)
+			buf = d.first.AppendFormat(buf, cJSONTimeFormat)
+			buf = append(buf, `","last":"`...)
+			buf = d.last.AppendFormat(buf, cJSONTimeFormat)
+			buf = append(buf, "\"}\n"...)
+			continue
+		}
+		buf = append(buf, d.line...)
+		buf = append(buf, " (x"...)
+		buf = strconv.AppendInt(buf, int64(d.count), 10)
+		buf = append(buf, " between "...)
+		buf = d.first.AppendFormat(buf, cLineTimeFormat)
+		buf = append(buf, " and "...)
+		buf = d.last.AppendFormat(buf, cLineTimeFormat)
+		buf = append(buf, ")\n"...)
+	}
+
+	if len(buf) > 0 && w != nil {
+		if _, err := w.Write(buf); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	s.dups = nil
+	s.dupOrder = nil
+}
+
+// allowSend checks the hourly cap. It is only used by the sending goroutine.
+func (s *MailSender) allowSend(now time.Time) bool {
+
+	max := int(atomic.LoadInt64(&s.maxPerHour))
+	if max <= 0 {
+		return true
+	}
+
+	i := 0
+	for i < len(s.sent) && now.Sub(s.sent[i]) >= time.Hour {
+		i++
+	}
+	s.sent = s.sent[i:]
+
+	return len(s.sent) < max
+}
+
+// suppress drops the files of a batch that is over the hourly cap.
+func (s *MailSender) suppress(files []string) {
+
+	if s.suppressed == 0 {
+		s.suppressedSince = time.Now()
+	}
+	s.suppressed++
+	s.suppressedFiles += len(files)
+
+	s.sendDone(files)
+}
+
+// sendNote is put at the top of the next email after something was suppressed.
+func (s *MailSender) sendNote() string {
+	if s.suppressed == 0 {
+		return s.spoolNote()
+	}
+	return s.spoolNote() + "!!! Rate limit: " + strconv.Itoa(s.suppressed) + " email(s) with " + strconv.Itoa(s.suppressedFiles) +
+		" log chunk(s) were suppressed since " + s.suppressedSince.UTC().Format(cLineTimeFormat) + " UTC !!!\n\n"
+}
+
+func (s *MailSender) markSent() {
+	s.sent = append(s.sent, time.Now())
+	s.suppressed = 0
+	s.suppressedFiles = 0
+	s.suppressedSince = time.Time{}
+	s.spoolDropped = 0
+	s.spoolDroppedBytes = 0
+	s.deadFiles = 0
+}
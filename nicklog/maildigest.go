@@ -0,0 +1,283 @@
+package nicklog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+const (
+	cDefDigestErrors = 10
+	cSourceKey       = "component"
+	cNoSource        = "-"
+)
+
+// DigestOptions turns alert emails into an HTML digest: message counts per level
+// and source, the first MaxErrors distinct errors inline and the logs gzipped.
+// The source is the "component" field of an entry.
+type DigestOptions struct {
+	// Template is executed with *DigestData, nil means DefaultDigestTemplate.
+	Template  *template.Template
+	MaxErrors int
+}
+
+type DigestData struct {
+	Subject string
+	Time    time.Time
+	Note    string
+	Total   int
+	Counts  []DigestCount
+	Errors  []DigestError
+	Files   []string
+}
+
+type DigestCount struct {
+	Level  string
+	Source string
+	Count  int
+}
+
+type DigestError struct {
+	Level string
+	Text  string
+	Count int
+}
+
+var DefaultDigestTemplate = template.Must(template.New("digest").Parse(`<html><body>
+{{if .Note}}<pre style="color:#c00">{{.Note}}</pre>{{end}}
+<h3>{{.Subject}} ({{.Time.Format "2006-01-02 15:04:05"}} UTC), {{.Total}} entries</h3>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Level</th><th>Source</th><th>Count</th></tr>
+{{range .Counts}}<tr><td>{{.Level}}</td><td>{{.Source}}</td><td align="right">{{.Count}}</td></tr>
+{{end}}</table>
+{{if .Errors}}<h4>First errors</h4>
+{{range .Errors}}<pre>{{.Text}}{{if gt .Count 1}} (x{{.Count}}){{end}}</pre>
+{{end}}{{end}}
+<p>Full logs attached: {{range .Files}}{{.}} {{end}}</p>
+</body></html>
+`))
+
+// SetDigest switches the sender to digest emails, nil restores plain text emails.
+func (s *MailSender) SetDigest(opts *DigestOptions) {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	s.digest = opts
+}
+
+func (s *MailSender) sendDigest(files []string) (err error) {
+
+	s.createLast()
+	defer s.delLast()
+
+	data, err := s.digestData(files)
+	if err != nil {
+		return
+	}
+
+	tmpl := s.digest.Template
+	if tmpl == nil {
+		tmpl = DefaultDigestTemplate
+	}
+
+	var body bytes.Buffer
+	if err = tmpl.Execute(&body, data); err != nil {
+		log.Println(err.Error())
+		body.Reset()
+		if err = DefaultDigestTemplate.Execute(&body, data); err != nil {
+			return
+		}
+	}
+
+	m := gomail.NewMessage()
+
+	m.SetHeader("To", s.rcpts...)
+	m.SetHeader("Subject", s.subj)
+	m.SetBody("text/html", body.String())
+
+	for i := 0; i < len(files); i++ {
+		fileName := filepath.Join(s.outDir, files[i])
+		m.Attach(fileName, gomail.Rename(files[i]+cGzipExt), gomail.SetCopyFunc(func(w io.Writer) error {
+			return gzipTo(w, fileName)
+		}))
+	}
+
+	for i := 0; i < len(s.servers); i++ {
+		m.SetHeader("From", s.servers[i].Sender)
+		if err = s.servers[i].send(m); err == nil {
+			break
+		}
+	}
+	return
+}
+
+func gzipTo(w io.Writer, fileName string) (err error) {
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	zw := gzip.NewWriter(w)
+	if _, err = io.Copy(zw, file); err != nil {
+		return
+	}
+
+	return zw.Close()
+}
+
+// digestData parses the spooled lines. Both text and JSON entries are understood,
+// lines without a timestamp (e.g. stack traces) are skipped, dedup summaries
+// "(xN between ...)" or "repeat":N count N times.
+func (s *MailSender) digestData(files []string) (data *DigestData, err error) {
+
+	data = &DigestData{
+		Subject: s.subj,
+		Time:    time.Now().UTC(),
+		Note:    s.sendNote(),
+		Files:   make([]string, len(files)),
+	}
+
+	maxErrors := s.digest.MaxErrors
+	if maxErrors <= 0 {
+		maxErrors = cDefDigestErrors
+	}
+
+	counts := make(map[[2]string]*DigestCount)
+	errs := make(map[string]int)
+
+	for i := 0; i < len(files); i++ {
+		data.Files[i] = files[i] + cGzipExt
+
+		file, err := os.Open(filepath.Join(s.outDir, files[i]))
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			level, source, msg, n, ok := parseSpoolLine(scanner.Text())
+			if !ok {
+				continue
+			}
+			data.Total += n
+
+			key := [2]string{level, source}
+			c := counts[key]
+			if c == nil {
+				c = &DigestCount{Level: level, Source: source}
+				counts[key] = c
+			}
+			c.Count += n
+
+			if lv, err := ParseLevel(level); err != nil || lv < LevelError {
+				continue
+			}
+			if j, ok := errs[level+" "+msg]; ok {
+				data.Errors[j].Count += n
+			} else if len(data.Errors) < maxErrors {
+				errs[level+" "+msg] = len(data.Errors)
+				data.Errors = append(data.Errors, DigestError{Level: level, Text: msg, Count: n})
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range counts {
+		data.Counts = append(data.Counts, *c)
+	}
+	sort.Slice(data.Counts, func(i, j int) bool {
+		li, _ := ParseLevel(data.Counts[i].Level)
+		lj, _ := ParseLevel(data.Counts[j].Level)
+		if li != lj {
+			return li > lj
+		}
+		return data.Counts[i].Source < data.Counts[j].Source
+	})
+
+	return
+}
+
+func parseSpoolLine(line string) (level string, source string, msg string, n int, ok bool) {
+
+	n = 1
+	source = cNoSource
+
+	if strings.HasPrefix(line, "{") {
+		var e map[string]interface{}
+		if json.Unmarshal([]byte(line), &e) != nil {
+			return
+		}
+		level, _ = e["level"].(string)
+		msg, _ = e["msg"].(string)
+		if v, ok := e[cSourceKey].(string); ok {
+			source = v
+		}
+		// dedup summary, the first occurrence is already counted
+		if v, ok := e["repeat"].(float64); ok && v > 1 {
+			n = int(v) - 1
+		}
+		return level, source, msg, n, len(level) > 0
+	}
+
+	if len(line) <= len(cLineTimeFormat) {
+		return
+	}
+	if _, err := time.Parse(cLineTimeFormat, line[:len(cLineTimeFormat)]); err != nil {
+		return
+	}
+	msg = strings.TrimSpace(line[len(cLineTimeFormat):])
+
+	// raw Print/Println lines have no level and are treated as INFO
+	level = LevelInfo.String()
+	i := strings.IndexByte(msg, ' ')
+	if i < 0 {
+		i = len(msg)
+	}
+	if _, ok := levelToken(msg[:i]); ok {
+		level = msg[:i]
+		msg = strings.TrimPrefix(msg[i:], " ")
+	}
+
+	// dedup summary "line (xN between T1 and T2)"
+	if strings.HasSuffix(msg, ")") {
+		if i := strings.LastIndex(msg, " (x"); i > 0 {
+			if j := strings.Index(msg[i:], " between "); j > 0 {
+				if cnt, err := strconv.Atoi(msg[i+3 : i+j]); err == nil && cnt > 1 {
+					// the first occurrence is already counted
+					n = cnt - 1
+					msg = msg[:i]
+				}
+			}
+		}
+	}
+
+	if i := strings.Index(msg, " "+cSourceKey+"="); i >= 0 {
+		v := msg[i+len(cSourceKey)+2:]
+		if j := strings.IndexByte(v, ' '); j >= 0 {
+			v = v[:j]
+		}
+		if len(v) > 0 {
+			source = strings.Trim(v, `"`)
+		}
+	}
+
+	return level, source, msg, n, true
+}
@@ -0,0 +1,27 @@
+package nicklog
+
+import "testing"
+
+func TestParseSpoolLine(t *testing.T) {
+
+	tests := []struct {
+		line  string
+		level string
+		msg   string
+		n     int
+	}{
+		{"2024-01-01 00:00:00 ERROR disk full", "ERROR", "disk full", 1},
+		{"2024-01-01 00:00:00 error while reading config", "INFO", "error while reading config", 1},
+		{"2024-01-01 00:00:00 Warning: low memory", "INFO", "Warning: low memory", 1},
+		{"2024-01-01 00:00:00 WARN", "WARN", "", 1},
+		{"2024-01-01 00:00:00 ERROR boom (x5 between 2024-01-01 00:00:00 and 2024-01-01 00:01:00)", "ERROR", "boom", 4},
+		{`{"time":"2024-01-01T00:00:00.000Z","level":"ERROR","msg":"boom","repeat":3}`, "ERROR", "boom", 2},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		level, _, msg, n, ok := parseSpoolLine(tests[i].line)
+		if !ok || level != tests[i].level || msg != tests[i].msg || n != tests[i].n {
+			t.Errorf("%q: got %q %q %d %v", tests[i].line, level, msg, n, ok)
+		}
+	}
+}
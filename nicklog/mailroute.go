@@ -0,0 +1,86 @@
+package nicklog
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MailRoute sends entries from Level up to the level of the next route
+// to its own recipients. An empty Subj means Config.MailSubj.
+type MailRoute struct {
+	Level Level
+	Rcpts []string
+	Subj  string
+}
+
+type mailRoute struct {
+	MailRoute
+	dir string
+}
+
+// initMail creates a MailSender per route. Config.MailRcpts is the route at
+// Config.MailLevel and keeps the old "maillog" spool directory, other routes
+// are spooled in "maillog/<level>".
+func (l *Logger) initMail(cfg *Config, prefix string) (err error) {
+
+	if len(cfg.MailServers) == 0 {
+		return nil
+	}
+
+	mailDir := filepath.Join(cfg.Dir, "maillog")
+
+	var routes []mailRoute
+	if len(cfg.MailRcpts) > 0 {
+		routes = append(routes, mailRoute{
+			MailRoute: MailRoute{Level: cfg.MailLevel, Rcpts: cfg.MailRcpts, Subj: cfg.MailSubj},
+			dir:       mailDir,
+		})
+	}
+
+	for i := 0; i < len(cfg.MailRoutes); i++ {
+		r := cfg.MailRoutes[i]
+		if len(r.Rcpts) == 0 {
+			return errors.New("Mail route " + r.Level.String() + " has no recipients")
+		}
+		if len(r.Subj) == 0 {
+			r.Subj = cfg.MailSubj
+		}
+		routes = append(routes, mailRoute{
+			MailRoute: r,
+			dir:       filepath.Join(mailDir, strings.ToLower(r.Level.String())),
+		})
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].Level < routes[j].Level
+	})
+
+	for i := 1; i < len(routes); i++ {
+		if routes[i].Level == routes[i-1].Level {
+			return errors.New("Duplicate mail route level: " + routes[i].Level.String())
+		}
+	}
+
+	for i := 0; i < len(routes); i++ {
+		s, err := NewMailSender(prefix, cfg.MailServers, routes[i].Rcpts, routes[i].Subj, routes[i].dir, cfg.MaxMsgSize, cfg.SendMsgPeriod)
+		if err != nil {
+			return err
+		}
+		s.SetLevel(routes[i].Level)
+		s.SetSendPeriod(cfg.MailSendPeriod)
+		s.SetMaxPerHour(cfg.MailMaxPerHour)
+		s.SetRetryPolicy(cfg.MailMaxAttempts, cfg.MailMaxAge)
+		s.SetMaxSpoolSize(cfg.MailMaxSpoolSize)
+		s.SetDigest(cfg.MailDigest)
+		if i+1 < len(routes) {
+			s.SetMaxLevel(routes[i+1].Level - 1)
+		}
+
+		l.mailSenders = append(l.mailSenders, s)
+		l.sinks = append(l.sinks, s)
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package nicklog
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"nicklib/nicklog/smtptest"
+)
+
+func testMailServer(srv *smtptest.Server) MailServer {
+	return MailServer{
+		Host:    srv.Host,
+		Port:    srv.Port,
+		Sender:  "log@example.com",
+		TLSMode: TLSNone,
+		Timeout: 5 * time.Second,
+	}
+}
+
+// newTestSender returns a MailSender whose background sending never fires
+// during the test, sendOut is called directly.
+func newTestSender(t *testing.T, dir string, servers ...MailServer) *MailSender {
+
+	s, err := NewMailSender("app", servers, []string{"ops@example.com"}, "alert", dir, 1024*1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetSendPeriod(time.Hour)
+
+	t.Cleanup(func() {
+		close(s.stop)
+		s.wg.Wait()
+	})
+
+	return s
+}
+
+// spool puts n batch files into the out directory, the oldest first.
+func spool(t *testing.T, dir string, n int) {
+	for i := 0; i < n; i++ {
+		name := "app" + strconv.Itoa(1000+i) + ".txt"
+		line := "2024-01-01 00:00:00 ERROR batch " + strconv.Itoa(i) + "\n"
+		if err := os.WriteFile(filepath.Join(dir, "out", name), []byte(line), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func sendOnce(t *testing.T, s *MailSender) {
+
+	s.sendLock.Lock()
+	sent, err := s.sendOut(true, false)
+	s.sendLock.Unlock()
+
+	if err != nil || !sent {
+		t.Fatalf("sendOut: sent %v, err %v", sent, err)
+	}
+}
+
+func outFiles(t *testing.T, dir string) int {
+	fs, err := os.ReadDir(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(fs)
+}
+
+func TestMailSenderBatching(t *testing.T) {
+
+	srv := smtptest.NewServer()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	s := newTestSender(t, dir, testMailServer(srv))
+
+	// a single file goes in the body
+	spool(t, dir, 1)
+	sendOnce(t, s)
+
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msgs))
+	}
+	if !bytes.Contains(msgs[0].Data, []byte("ERROR batch 0")) {
+		t.Errorf("body doesn't hold the log:\n%s", msgs[0].Data)
+	}
+	if bytes.Contains(msgs[0].Data, []byte("attachment")) {
+		t.Errorf("single file sent as attachment:\n%s", msgs[0].Data)
+	}
+
+	// more files go as attachments, 10 per message
+	srv.Reset()
+	spool(t, dir, 12)
+	sendOnce(t, s)
+
+	msgs = srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msgs))
+	}
+	if n := bytes.Count(msgs[0].Data, []byte("Content-Disposition: attachment")); n != 10 {
+		t.Errorf("got %d attachments, want 10", n)
+	}
+	if n := outFiles(t, dir); n != 2 {
+		t.Errorf("%d files left in out, want 2", n)
+	}
+}
+
+func TestMailSenderFailover(t *testing.T) {
+
+	srv1 := smtptest.NewServer()
+	defer srv1.Close()
+	srv2 := smtptest.NewServer()
+	defer srv2.Close()
+
+	dir := t.TempDir()
+	s := newTestSender(t, dir, testMailServer(srv1), testMailServer(srv2))
+
+	srv1.FailNext(1)
+	spool(t, dir, 1)
+	sendOnce(t, s)
+
+	if n := len(srv1.Messages()); n != 0 {
+		t.Errorf("failing server got %d messages", n)
+	}
+	if n := len(srv2.Messages()); n != 1 {
+		t.Errorf("second server got %d messages, want 1", n)
+	}
+	if n := outFiles(t, dir); n != 0 {
+		t.Errorf("%d files left in out, want 0", n)
+	}
+
+	// the first server is used again once it works
+	spool(t, dir, 1)
+	sendOnce(t, s)
+
+	if n := len(srv1.Messages()); n != 1 {
+		t.Errorf("first server got %d messages, want 1", n)
+	}
+}
+
+func TestMailSenderLast(t *testing.T) {
+
+	srv := smtptest.NewServer()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	spool(t, dir, 1)
+
+	// a send interrupted by a crash leaves "last", the next start waits
+	// one more period before sending again
+	last := filepath.Join(dir, "last")
+	if err := os.WriteFile(last, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	period := 100 * time.Millisecond
+	start := time.Now()
+
+	s, err := NewMailSender("app", []MailServer{testMailServer(srv)}, []string{"ops@example.com"}, "alert", dir, 1024*1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		close(s.stop)
+		s.wg.Wait()
+	}()
+	s.SetSendPeriod(period)
+
+	if _, ok := srv.Wait(1, time.Second); !ok {
+		t.Fatal("nothing delivered within 1s")
+	}
+	if elapsed := time.Since(start); elapsed < 2*period {
+		t.Errorf("sent after %v, want at least %v", elapsed, 2*period)
+	}
+	// removed right after the server's reply
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(last); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("last marker left after the send")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoggerMail(t *testing.T) {
+
+	srv := smtptest.NewServer()
+	defer srv.Close()
+
+	l, err := NewLoggerFromConfig(Config{
+		Dir:            t.TempDir(),
+		FileName:       "app.log",
+		MaxSize:        1024,
+		MaxFiles:       2,
+		MailServers:    []MailServer{testMailServer(srv)},
+		MailRcpts:      []string{"ops@example.com"},
+		MailLevel:      LevelError,
+		MaxMsgSize:     1024 * 1024,
+		SendMsgPeriod:  50 * time.Millisecond,
+		MailSendPeriod: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Info("not mailed")
+	l.Error("disk full")
+
+	msgs, ok := srv.Wait(1, time.Second)
+	if !ok {
+		t.Fatal("nothing delivered within 1s")
+	}
+	if !bytes.Contains(msgs[0].Data, []byte("ERROR disk full")) {
+		t.Errorf("error not mailed:\n%s", msgs[0].Data)
+	}
+	if bytes.Contains(msgs[0].Data, []byte("not mailed")) {
+		t.Errorf("info entry mailed:\n%s", msgs[0].Data)
+	}
+}
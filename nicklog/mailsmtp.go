@@ -0,0 +1,183 @@
+package nicklog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+const cDefMailTimeout = 30 * time.Second
+
+type TLSMode int
+
+const (
+	// TLSAuto is the old behaviour: implicit TLS on port 465, otherwise STARTTLS
+	// when the server offers it. With IsTLS set STARTTLS is required.
+	TLSAuto TLSMode = iota
+	// TLSNone never encrypts the connection.
+	TLSNone
+	// TLSStartTLS fails if the server doesn't offer STARTTLS.
+	TLSStartTLS
+	// TLSImplicit connects with TLS right away (SMTPS).
+	TLSImplicit
+)
+
+func (s *MailServer) tlsMode() TLSMode {
+	if s.TLSMode != TLSAuto {
+		return s.TLSMode
+	}
+	switch {
+	case s.Port == 465:
+		return TLSImplicit
+	case s.IsTLS:
+		return TLSStartTLS
+	}
+	return TLSAuto
+}
+
+func (s *MailServer) tlsConfig() (*tls.Config, error) {
+
+	c := &tls.Config{
+		ServerName:         s.Host,
+		RootCAs:            s.RootCAs,
+		InsecureSkipVerify: s.InsecureSkipVerify,
+	}
+
+	if c.RootCAs == nil && len(s.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates in " + s.CAFile)
+		}
+	}
+
+	return c, nil
+}
+
+// send delivers m through this server. Timeout bounds the dial
+// and the whole SMTP session.
+func (s *MailServer) send(m *gomail.Message) (err error) {
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = cDefMailTimeout
+	}
+
+	mode := s.tlsMode()
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return
+	}
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	if mode == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer c.Close()
+
+	if mode == TLSAuto || mode == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err = c.StartTLS(tlsConfig); err != nil {
+				return
+			}
+		} else if mode == TLSStartTLS {
+			return errors.New("Server " + addr + " doesn't support STARTTLS")
+		}
+	}
+
+	if len(s.UserName) > 0 {
+		if ok, auths := c.Extension("AUTH"); ok {
+			if err = c.Auth(s.auth(auths)); err != nil {
+				return
+			}
+		}
+	}
+
+	err = gomail.Send(gomail.SendFunc(func(from string, to []string, msg io.WriterTo) error {
+		if err := c.Mail(from); err != nil {
+			return err
+		}
+		for i := 0; i < len(to); i++ {
+			if err := c.Rcpt(to[i]); err != nil {
+				return err
+			}
+		}
+		w, err := c.Data()
+		if err != nil {
+			return err
+		}
+		if _, err = msg.WriteTo(w); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	}), m)
+	if err != nil {
+		return
+	}
+
+	return c.Quit()
+}
+
+// auth picks the mechanism the same way gomail does.
+func (s *MailServer) auth(auths string) smtp.Auth {
+	switch {
+	case strings.Contains(auths, "CRAM-MD5"):
+		return smtp.CRAMMD5Auth(s.UserName, s.Password)
+	case strings.Contains(auths, "LOGIN") && !strings.Contains(auths, "PLAIN"):
+		return &loginAuth{username: s.UserName, password: s.Password}
+	default:
+		return smtp.PlainAuth("", s.UserName, s.Password, s.Host)
+	}
+}
+
+type loginAuth struct {
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch {
+	case bytes.Equal(fromServer, []byte("Username:")):
+		return []byte(a.username), nil
+	case bytes.Equal(fromServer, []byte("Password:")):
+		return []byte(a.password), nil
+	default:
+		return nil, errors.New("Unexpected server challenge: " + string(fromServer))
+	}
+}
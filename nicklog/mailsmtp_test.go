@@ -0,0 +1,26 @@
+package nicklog
+
+import "testing"
+
+func TestTLSMode(t *testing.T) {
+
+	tests := []struct {
+		server MailServer
+		want   TLSMode
+	}{
+		{MailServer{Port: 25}, TLSAuto},
+		{MailServer{Port: 465}, TLSImplicit},
+		{MailServer{Port: 465, IsTLS: true}, TLSImplicit},
+		{MailServer{Port: 587, IsTLS: true}, TLSStartTLS},
+		{MailServer{Port: 25, IsTLS: true}, TLSStartTLS},
+		{MailServer{Port: 465, TLSMode: TLSNone}, TLSNone},
+		{MailServer{Port: 587, IsTLS: true, TLSMode: TLSImplicit}, TLSImplicit},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		if got := tests[i].server.tlsMode(); got != tests[i].want {
+			t.Errorf("port %d IsTLS %v mode %d: got %d, want %d", tests[i].server.Port, tests[i].server.IsTLS,
+				tests[i].server.TLSMode, got, tests[i].want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package nicklog
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const cRetryMax = 1 * time.Hour
+
+// spoolState is the retry state of one file in the out directory.
+// It lives in memory only, after a restart every file starts from scratch.
+type spoolState struct {
+	attempts int
+	next     time.Time
+}
+
+// SetRetryPolicy moves a message to the dead-letter directory after maxAttempts
+// failed sends or when it is older than maxAge. Zero disables the rule.
+func (s *MailSender) SetRetryPolicy(maxAttempts int, maxAge time.Duration) {
+	atomic.StoreInt64(&s.maxAttempts, int64(maxAttempts))
+	atomic.StoreInt64(&s.maxAge, int64(maxAge))
+}
+
+// SetMaxSpoolSize limits the total size of the out directory. The oldest
+// messages are dropped first and the next email says how much was lost.
+func (s *MailSender) SetMaxSpoolSize(size int64) {
+	atomic.StoreInt64(&s.maxSpoolSize, size)
+}
+
+// checkSpool applies the spool cap and the retry policy to the files
+// of the out directory (oldest first) and returns those ready to be sent.
+// With force files waiting for their retry delay are returned too.
+func (s *MailSender) checkSpool(infos []os.FileInfo, force bool) (ready []string) {
+
+	now := time.Now()
+
+	if maxSize := atomic.LoadInt64(&s.maxSpoolSize); maxSize > 0 {
+		var total int64
+		for i := 0; i < len(infos); i++ {
+			total += infos[i].Size()
+		}
+		for len(infos) > 0 && total > maxSize {
+			if err := os.Remove(filepath.Join(s.outDir, infos[0].Name())); err != nil {
+				log.Println(err.Error())
+			} else {
+				s.spoolDropped++
+				s.spoolDroppedBytes += infos[0].Size()
+			}
+			delete(s.retry, infos[0].Name())
+			total -= infos[0].Size()
+			infos = infos[1:]
+		}
+	}
+
+	maxAttempts := int(atomic.LoadInt64(&s.maxAttempts))
+	maxAge := time.Duration(atomic.LoadInt64(&s.maxAge))
+
+	for i := 0; i < len(infos); i++ {
+		fileName := infos[i].Name()
+		st := s.retry[fileName]
+
+		if (maxAge > 0 && now.Sub(infos[i].ModTime()) > maxAge) || (st != nil && maxAttempts > 0 && st.attempts >= maxAttempts) {
+			s.toDead(fileName)
+			continue
+		}
+		if st != nil && !force && now.Before(st.next) {
+			continue
+		}
+		ready = append(ready, fileName)
+	}
+
+	return
+}
+
+// sendFailed doubles the delay of every file of a failed batch.
+func (s *MailSender) sendFailed(files []string) {
+
+	if s.retry == nil {
+		s.retry = make(map[string]*spoolState)
+	}
+
+	now := time.Now()
+	for i := 0; i < len(files); i++ {
+		st := s.retry[files[i]]
+		if st == nil {
+			st = &spoolState{}
+			s.retry[files[i]] = st
+		}
+		st.attempts++
+
+		delay := cRetryMax
+		if st.attempts < 16 {
+			if d := s.getSendPeriod() << uint(st.attempts-1); d < cRetryMax {
+				delay = d
+			}
+		}
+		st.next = now.Add(delay)
+	}
+}
+
+// sendDone removes the sent files from the out directory.
+func (s *MailSender) sendDone(files []string) {
+	for i := 0; i < len(files); i++ {
+		delete(s.retry, files[i])
+		if err := os.Remove(filepath.Join(s.outDir, files[i])); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// toDead moves a message that can't be delivered to the dead-letter directory.
+func (s *MailSender) toDead(fileName string) {
+
+	delete(s.retry, fileName)
+
+	if err := os.MkdirAll(s.deadDir, os.ModeDir|0755); err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	if err := os.Rename(filepath.Join(s.outDir, fileName), filepath.Join(s.deadDir, fileName)); err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	s.deadFiles++
+}
+
+// spoolNote describes spool losses since the last successful send.
+func (s *MailSender) spoolNote() (note string) {
+
+	if s.spoolDropped > 0 {
+		note += "!!! Spool limit: " + strconv.Itoa(s.spoolDropped) + " log chunk(s), " + strconv.FormatInt(s.spoolDroppedBytes, 10) +
+			" bytes, were dropped !!!\n"
+	}
+
+	if s.deadFiles > 0 {
+		note += "!!! " + strconv.Itoa(s.deadFiles) + " log chunk(s) could not be sent and were moved to " + s.deadDir + " !!!\n"
+	}
+
+	if len(note) > 0 {
+		note += "\n"
+	}
+
+	return
+}
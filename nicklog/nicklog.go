@@ -1,6 +1,9 @@
 package nicklog
 
 import (
+	"bytes"
+	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
@@ -9,7 +12,6 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,7 +23,13 @@ import (
 
 const (
 	cTimeFormat     = "2006-01-02_15-04-05.000"
+	cLineTimeFormat = "2006-01-02 15:04:05"
 	cMailMsgBufSize = 10024
+
+	cMailSendPeriod    = 1 * time.Minute
+	cRotateRetryPeriod = 1 * time.Minute
+	cCloseTimeout      = 30 * time.Second
+	cAbortTimeout      = 1 * time.Second
 )
 
 type MailServer struct {
@@ -31,99 +39,306 @@ type MailServer struct {
 	Sender   string
 	Password string
 	IsTLS    bool
+
+	TLSMode TLSMode
+	// CAFile is a PEM bundle used instead of the system roots, RootCAs takes precedence.
+	CAFile             string
+	RootCAs            *x509.CertPool
+	InsecureSkipVerify bool
+	// Timeout bounds connecting and the whole SMTP session, 30s by default.
+	Timeout time.Duration
 }
 
+// Logger is the handle used for logging. Child loggers made by With share
+// the logCore (file, rotation, sinks) of their parent and add their fields.
 type Logger struct {
-	fileName   string
-	fileExt    string
-	dir        string
-	arcDir     string
-	maxSize    int64
-	maxFiles   int
-	file       *os.File
-	lock       sync.Mutex
-	size       int64
-	mailSender *MailSender
+	*logCore
+	fields     []Field
+	callerSkip int
+	sampleKey  string
 }
 
+type logCore struct {
+	fileName string
+	fileExt  string
+	dir      string
+	arcDir   string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	lock     sync.Mutex
+	size     int64
+	level    int32
+	format   int32
+
+	mailSenders []*MailSender
+	sinks       []Sink
+
+	maxAge     time.Duration
+	maxArcSize int64
+	onDelete   func(fileName string, reason string)
+
+	onError     func(err error)
+	rotateRetry time.Time
+
+	rotateInterval RotateInterval
+	rotateAt       time.Duration
+	nextRotate     time.Time
+
+	async *asyncWriter
+
+	compress     int32
+	compressOnce sync.Once
+	compressCh   chan struct{}
+	compressDone chan struct{}
+	// arcLock serializes delOld with the end of a compression
+	arcLock sync.Mutex
+
+	fileCheckPeriod time.Duration
+	nextFileCheck   time.Time
+	reopenStop      chan struct{}
+
+	addCaller int32
+	addStack  int32
+
+	panicReturn int32
+
+	sampling atomic.Pointer[sampler]
+}
+
+// Config holds all Logger settings. Zero values mean the defaults
+// used by NewLogger, MaxSize is in kilobytes.
+type Config struct {
+	Dir      string
+	FileName string
+	MaxSize  int64
+	MaxFiles int
+	ArcDir   string
+
+	Level  Level
+	Format Format
+
+	RotateInterval RotateInterval
+	RotateAt       time.Duration
+	Compression    Compression
+
+	MaxAge     time.Duration
+	MaxArcSize int64
+	OnDelete   func(fileName string, reason string)
+
+	// OnError is called when rotation fails, the default is log.Println.
+	OnError func(err error)
+
+	// FileCheckPeriod is how often the file is checked for being deleted or
+	// replaced (e.g. by logrotate) and reopened, 0 means every second and
+	// a negative value disables the check.
+	FileCheckPeriod time.Duration
+
+	// AddCaller annotates entries with the file:line of the caller, CallerSkip
+	// skips more frames for wrappers. AddStack adds the stack trace to Error
+	// and Fatal entries.
+	AddCaller  bool
+	CallerSkip int
+	AddStack   bool
+
+	// PanicReturn makes Recover return after logging a panic instead of panicking again.
+	PanicReturn bool
+
+	// Sampling limits floods of similar entries, nil writes everything.
+	Sampling *Sampling
+
+	// Async enables the buffered mode: entries are queued and written
+	// by a background goroutine, Flush and Close drain the queue.
+	Async           bool
+	AsyncBufferSize int
+	AsyncOverflow   OverflowPolicy
+
+	// Sinks get a copy of every entry in addition to the file and the mail sender.
+	Sinks []Sink
+
+	MailServers   []MailServer
+	MailRcpts     []string
+	MailSubj      string
+	MailLevel     Level
+	MailRoutes    []MailRoute
+	MaxMsgSize    int
+	SendMsgPeriod time.Duration
+
+	// MailSendPeriod is how often spooled mail is sent, one minute by default.
+	MailSendPeriod time.Duration
+
+	// MailMaxPerHour caps emails per sender and hour, 0 means no limit.
+	MailMaxPerHour int
+
+	// Failed sends are retried with exponential backoff. A message is moved to
+	// maillog/dead after MailMaxAttempts sends or when older than MailMaxAge.
+	MailMaxAttempts int
+	MailMaxAge      time.Duration
+	// MailMaxSpoolSize caps the size of unsent mail, the oldest is dropped first.
+	MailMaxSpoolSize int64
+
+	// MailDigest sends HTML digests with gzipped logs instead of plain text emails.
+	MailDigest *DigestOptions
+
+	// Webhook posts entries from WebhookLevel up, spooled in "webhook" like mail.
+	// The Mail* period, retry and spool settings apply to it too.
+	Webhook      *Webhook
+	WebhookLevel Level
+}
+
+// NewLogger is kept for compatibility, new settings are only available through NewLoggerFromConfig.
 func NewLogger(dir string, fileName string, maxSize int64, maxFiles int, arcDir string,
 	mailServers []MailServer, mailRcpts []string, mailSubj string, maxMsgSize int, sendMsgPeriod time.Duration) (l *Logger, err error) {
 
-	if maxSize < 100 {
+	return NewLoggerFromConfig(Config{
+		Dir:           dir,
+		FileName:      fileName,
+		MaxSize:       maxSize,
+		MaxFiles:      maxFiles,
+		ArcDir:        arcDir,
+		MailServers:   mailServers,
+		MailRcpts:     mailRcpts,
+		MailSubj:      mailSubj,
+		MaxMsgSize:    maxMsgSize,
+		SendMsgPeriod: sendMsgPeriod,
+	})
+}
+
+func NewLoggerFromConfig(cfg Config) (l *Logger, err error) {
+
+	if cfg.MaxSize < 100 {
 		return nil, errors.New("maxSize is less 100")
 	}
-	if maxFiles < 1 {
+	if cfg.MaxFiles < 1 {
 		return nil, errors.New("maxFiles is less 1")
 	}
 
-	if len(fileName) == 0 {
+	if len(cfg.FileName) == 0 {
 		return nil, errors.New("FileName is not set")
 	}
 
-	if len(dir) == 0 {
+	if len(cfg.Dir) == 0 {
 		return nil, errors.New("dir is not set")
 	}
 
-	if len(arcDir) == 0 {
-		arcDir = dir
+	if len(cfg.ArcDir) == 0 {
+		cfg.ArcDir = cfg.Dir
 	}
 
-	if len(mailSubj) == 0 {
-		mailSubj = "Fatal error!"
+	if len(cfg.MailSubj) == 0 {
+		cfg.MailSubj = "Fatal error!"
 	}
 
 	l = &Logger{
-		dir:      dir,
-		fileName: fileName,
-		fileExt:  filepath.Ext(fileName),
-		maxSize:  maxSize * 1024,
-		maxFiles: maxFiles,
-		arcDir:   arcDir,
+		logCore: &logCore{
+			dir:        cfg.Dir,
+			fileName:   cfg.FileName,
+			fileExt:    filepath.Ext(cfg.FileName),
+			maxSize:    cfg.MaxSize * 1024,
+			maxFiles:   cfg.MaxFiles,
+			arcDir:     cfg.ArcDir,
+			level:      int32(cfg.Level),
+			format:     int32(cfg.Format),
+			maxAge:     cfg.MaxAge,
+			maxArcSize: cfg.MaxArcSize,
+			onDelete:   cfg.OnDelete,
+			onError:    cfg.OnError,
+			addCaller:  boolInt32(cfg.AddCaller),
+			addStack:   boolInt32(cfg.AddStack),
+
+			panicReturn: boolInt32(cfg.PanicReturn),
+		},
+		callerSkip: cfg.CallerSkip,
+	}
+
+	switch {
+	case cfg.FileCheckPeriod == 0:
+		l.fileCheckPeriod = cFileCheckPeriod
+	case cfg.FileCheckPeriod > 0:
+		l.fileCheckPeriod = cfg.FileCheckPeriod
 	}
 
-	ss := strings.Split(fileName, ".")
+	ss := strings.Split(cfg.FileName, ".")
 	prefix := ""
 	if len(ss) > 0 {
 		prefix = ss[0]
 	}
 
-	if len(mailServers) > 0 && len(mailRcpts) > 0 {
-		l.mailSender, err = NewMailSender(prefix, mailServers, mailRcpts, mailSubj, filepath.Join(dir, "maillog"), maxMsgSize, sendMsgPeriod)
-		if err != nil {
-			return nil, err
-		}
+	if err = l.initMail(&cfg, prefix); err != nil {
+		l.closeSinks()
+		return nil, err
+	}
+
+	if err = l.initWebhook(&cfg, prefix); err != nil {
+		l.closeSinks()
+		return nil, err
 	}
 
+	l.sinks = append(l.sinks, cfg.Sinks...)
+
 	if err := l.delOld(); err != nil {
+		l.closeSinks()
 		return nil, err
 	}
 
 	// open log file...
 	if fileInfo, err := os.Stat(filepath.Join(l.dir, l.fileName)); err != nil {
 		if err = l.createFile(); err != nil {
+			l.closeSinks()
 			return nil, err
 		}
 	} else {
 		// file exists, check size...
 		if !fileInfo.IsDir() && fileInfo.Size() < l.maxSize {
-			// open for read-write
-			if l.file, err = os.OpenFile(filepath.Join(l.dir, l.fileName), os.O_RDWR, 0666); err != nil {
+			if err = l.openFile(); err != nil {
+				l.closeSinks()
 				return nil, err
 			}
-			l.file.Seek(0, 2)
-			l.size = fileInfo.Size()
 		} else {
 			if err := l.rotate(); err != nil {
+				l.closeSinks()
 				return nil, err
 			}
 		}
 	}
 
+	if err = l.SetRotation(cfg.RotateInterval, cfg.RotateAt); err != nil {
+		l.close()
+		l.closeSinks()
+		return nil, err
+	}
+
+	if cfg.Compression != CompressNone {
+		l.SetCompression(cfg.Compression)
+	}
+
+	if cfg.Async {
+		l.async = newAsyncWriter(l, cfg.AsyncBufferSize, cfg.AsyncOverflow)
+	}
+
+	if cfg.Sampling != nil {
+		l.SetSampling(cfg.Sampling)
+	}
+
 	rand.Seed(time.Now().Unix())
 
 	return
 }
 
+// closeSinks stops the sinks started by a NewLoggerFromConfig that fails.
+// Whatever they can't send at once stays in their spools.
+func (l *Logger) closeSinks() {
+
+	ctx, cancel := context.WithTimeout(context.Background(), cAbortTimeout)
+	defer cancel()
+
+	for i := 0; i < len(l.sinks); i++ {
+		l.sinks[i].Close(ctx)
+	}
+	l.sinks = nil
+	l.mailSenders = nil
+}
+
 func (l *Logger) GetWriter() (w io.Writer) {
 	return l.file
 }
@@ -136,89 +351,140 @@ func (l *Logger) createFile() (err error) {
 	return
 }
 
+// rotate moves the current file to arcDir and creates a new one. If the file
+// cannot be moved it is reopened, so the caller can keep writing to it.
 func (l *Logger) rotate() (err error) {
-	// delete old
-	if err = l.delOld(); err != nil {
-		return
+	// delete old, retention problems must not stop the rotation
+	if err := l.delOld(); err != nil {
+		l.reportError(err)
 	}
 	// close already opened
-	if err = l.close(); err != nil {
-		return
+	if err := l.close(); err != nil {
+		l.reportError(err)
+	}
+	l.file = nil
+
+	// a time rotation is named by the boundary crossed, not by the first write after it
+	arcTime := time.Now()
+	if !l.nextRotate.IsZero() && !arcTime.Before(l.nextRotate) {
+		arcTime = l.nextRotate
 	}
 
 	// rename current...
-	newFileName := l.fileName[:len(l.fileName)-len(l.fileExt)] + "_" + time.Now().Format(cTimeFormat) + l.fileExt
+	newFileName := l.fileName[:len(l.fileName)-len(l.fileExt)] + "_" + arcTime.Format(cTimeFormat) + l.fileExt
 	if err = os.Rename(filepath.Join(l.dir, l.fileName), filepath.Join(l.arcDir, newFileName)); err != nil {
+		if _, serr := os.Stat(filepath.Join(l.dir, l.fileName)); serr == nil {
+			if oerr := l.openFile(); oerr != nil {
+				l.reportError(oerr)
+			}
+		}
 		return
 	}
 
+	l.signalCompress()
+
+	if l.rotateInterval != RotateNone {
+		l.nextRotate = l.nextRotateTime(time.Now())
+	}
+
 	// open new...
 	return l.createFile()
 }
 
-func (l *Logger) close() (err error) {
-	if l.file != nil {
-		err = l.file.Close()
+// openFile opens dir/fileName for appending, creating it if needed.
+func (l *Logger) openFile() (err error) {
+
+	file, err := os.OpenFile(filepath.Join(l.dir, l.fileName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return
 	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+
+	l.file = file
+	l.size = fileInfo.Size()
+
 	return
 }
 
-func (l *Logger) delOld() (err error) {
-	for {
-		ok, err := l.delFile()
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return nil
-		}
+// checkRotate is called under l.lock. Failures put the logger into degraded mode:
+// it keeps writing to the current file (or stderr if there is none), reports the
+// error and tries again after cRotateRetryPeriod.
+func (l *Logger) checkRotate(n int) {
+
+	if !l.rotateRetry.IsZero() && time.Now().Before(l.rotateRetry) {
+		return
 	}
-}
 
-func (l *Logger) delFile() (deleted bool, err error) {
+	var err error
+	if l.file == nil {
+		err = l.openFile()
+	} else if l.needRotate(n) {
+		if err = l.rotate(); err != nil {
+			err = errors.New("Cannot rotate: " + err.Error())
+		}
+	} else {
+		return
+	}
 
-	files, err := ioutil.ReadDir(l.dir)
 	if err != nil {
-		return false, err
+		l.rotateRetry = time.Now().Add(cRotateRetryPeriod)
+		l.reportError(err)
+		return
 	}
+	l.rotateRetry = time.Time{}
+}
 
-	if len(files) < l.maxFiles {
-		return false, nil
-	}
+// SetErrorHandler sets f to be called on rotation and file errors instead of log.Println.
+// The handler runs with the logger locked, so it must not write to the same Logger.
+func (l *Logger) SetErrorHandler(f func(err error)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.onError = f
+}
 
-	// lets find the oldiest and kill'em
-	minId := -1
-	cnt := 0
-	minDateTime := time.Time{}
-	for i := 0; i < len(files); i++ {
-		fileName := files[i].Name()
-		if len(fileName) == (len(l.fileName) + len(cTimeFormat) + 1) {
-			if fileName[:len(l.fileName)-len(l.fileExt)] == l.fileName[:len(l.fileName)-len(l.fileExt)] && filepath.Ext(fileName) == l.fileExt {
-				cnt++
-				if fileDateTime, err := time.Parse(cTimeFormat, fileName[len(l.fileName)-len(l.fileExt)+1:len(l.fileName)-len(l.fileExt)+len(cTimeFormat)+1]); err == nil {
-					if minDateTime.IsZero() || fileDateTime.Before(minDateTime) {
-						minDateTime = fileDateTime
-						minId = i
-					}
-				}
-			}
-		}
+func (l *Logger) reportError(err error) {
+	if l.onError != nil {
+		l.onError(err)
+	} else {
+		log.Println(err.Error())
 	}
+}
 
-	if minDateTime.IsZero() || cnt < (l.maxFiles-1) {
-		return false, nil
+func (l *Logger) close() (err error) {
+	if l.file != nil {
+		err = l.file.Close()
 	}
+	return
+}
 
-	// delete old...
-	if err := os.Remove(filepath.Join(l.dir, files[minId].Name())); err != nil {
-		return false, err
+// parseArcName returns the rotation time of an archive name
+// like "app_2006-01-02_15-04-05.000.log", optionally compressed.
+func (l *Logger) parseArcName(fileName string) (t time.Time, ok bool) {
+
+	fileName = strings.TrimSuffix(fileName, cGzipExt)
+	base := l.fileName[:len(l.fileName)-len(l.fileExt)]
+
+	if len(fileName) != (len(l.fileName)+len(cTimeFormat)+1) || fileName[:len(base)] != base || fileName[len(base)] != '_' || !strings.HasSuffix(fileName, l.fileExt) {
+		return
 	}
 
-	return true, nil
+	// the name is formatted in local time
+	t, err := time.ParseInLocation(cTimeFormat, fileName[len(base)+1:len(base)+1+len(cTimeFormat)], time.Local)
+
+	return t, err == nil
 }
 
 func (l *Logger) Printf(format string, args ...interface{}) {
 
+	if !l.sample(LevelInfo, format) {
+		return
+	}
+
 	fmt.Fprintf(l, format, args...)
 
 }
@@ -226,7 +492,7 @@ func (l *Logger) Printf(format string, args ...interface{}) {
 func (l *Logger) Println(a ...interface{}) (n int, err error) {
 
 	var args []interface{}
-	args = append(args, time.Now().Format("2006-01-02 15:04:05"))
+	args = append(args, time.Now().Format(cLineTimeFormat))
 	args = append(args, a...)
 
 	n, err = fmt.Fprintln(l, args...)
@@ -237,7 +503,7 @@ func (l *Logger) Println(a ...interface{}) (n int, err error) {
 func (l *Logger) Print(a ...interface{}) (n int, err error) {
 
 	var args []interface{}
-	args = append(args, time.Now().Format("2006-01-02 15:04:05"))
+	args = append(args, time.Now().Format(cLineTimeFormat))
 	args = append(args, a...)
 
 	n, err = fmt.Fprint(l, args...)
@@ -245,33 +511,169 @@ func (l *Logger) Print(a ...interface{}) (n int, err error) {
 	return
 }
 
+// Write implements io.Writer. Raw writes (Print, Println, Printf) are treated
+// as LevelInfo when compared with the mail threshold.
 func (l *Logger) Write(p []byte) (n int, err error) {
 
-	l.lock.Lock()
-	defer l.lock.Unlock()
+	e := Entry{
+		Time:  time.Now(),
+		Level: LevelInfo,
+	}
+
+	if l.GetFormat() == FormatJSON {
+		return len(p), l.writeRawJSON(&e, p)
+	}
+
+	line := p
+	if len(l.fields) > 0 {
+		line = l.appendRawFields(p)
+	}
 
-	if (l.size + int64(len(p))) > l.maxSize {
-		if err := l.rotate(); err != nil {
-			panic("Cannot rotatate: " + err.Error())
+	if l.async != nil {
+		buf := getBuf()
+		*buf = append(*buf, line...)
+		if l.async.push(&e, buf) {
+			return len(p), nil
 		}
+		putBuf(buf)
+	}
+
+	e.Line = line
+
+	// io.Writer reports the length of p, not of the fields added
+	if n, err = l.write(&e); n > len(p) {
+		n = len(p)
+	}
+
+	return
+}
+
+// writeRawJSON writes p as the msg of a JSON entry, without the timestamp
+// added by Print and Println.
+func (l *Logger) writeRawJSON(e *Entry, p []byte) (err error) {
+
+	if len(p) >= len(cLineTimeFormat) {
+		if _, err := time.Parse(cLineTimeFormat, string(p[:len(cLineTimeFormat)])); err == nil {
+			p = bytes.TrimPrefix(p[len(cLineTimeFormat):], []byte(" "))
+		}
+	}
+	e.Msg = string(p)
+	e.Fields = l.fields
+
+	buf := getBuf()
+	*buf = appendJSONEntry(*buf, e)
+
+	if l.async != nil && l.async.push(e, buf) {
+		return nil
 	}
 
+	e.Line = *buf
+	_, err = l.write(e)
+	e.Line = nil
+	putBuf(buf)
+
+	return
+}
+
+// appendRawFields puts the fields of a child logger before the line break of p.
+func (l *Logger) appendRawFields(p []byte) []byte {
+
+	nl := 0
+	for nl < len(p) && p[len(p)-1-nl] == '\n' {
+		nl++
+	}
+
+	line := make([]byte, 0, len(p)+32*len(l.fields))
+	line = append(line, p[:len(p)-nl]...)
+	for i := 0; i < len(l.fields); i++ {
+		line = append(line, ' ')
+		line = append(line, l.fields[i].Key...)
+		line = append(line, '=')
+		line = appendTextValue(line, &l.fields[i])
+	}
+
+	return append(line, p[len(p)-nl:]...)
+}
+
+// write puts e.Line to the file and passes the entry to the sinks.
+func (l *Logger) write(e *Entry) (n int, err error) {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.checkFile()
+	l.checkRotate(len(e.Line))
+
 	// write to file
-	n, err = l.file.Write(p)
-	l.size += int64(n)
+	if l.file != nil {
+		n, err = l.file.Write(e.Line)
+		l.size += int64(n)
+	} else {
+		n, err = os.Stderr.Write(e.Line)
+	}
 
-	if l.mailSender != nil {
-		l.mailSender.Write(p)
+	for i := 0; i < len(l.sinks); i++ {
+		if l.sinks[i].Enabled(e.Level) {
+			if serr := l.sinks[i].WriteEntry(e); serr != nil {
+				l.reportError(serr)
+			}
+		}
 	}
 
 	return n, err
 }
 
-// Close implements io.Closer, and closes the current logfile.
+// Close implements io.Closer, and closes the current logfile and the sinks,
+// mail senders get up to cCloseTimeout for the final send.
+// In async mode the buffered entries are written first.
 func (l *Logger) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), cCloseTimeout)
+	defer cancel()
+	return l.CloseContext(ctx)
+}
+
+// CloseContext is Close with a deadline for the sinks, e.g. for the final mail send.
+func (l *Logger) CloseContext(ctx context.Context) (err error) {
+
+	// the last suppressed counts go through the async queue too
+	if s := l.sampling.Swap(nil); s != nil {
+		s.close()
+	}
+
+	if l.async != nil {
+		l.async.close()
+	}
+
 	l.lock.Lock()
-	defer l.lock.Unlock()
-	return l.close()
+	if l.reopenStop != nil {
+		close(l.reopenStop)
+		l.reopenStop = nil
+	}
+	if l.compressCh != nil {
+		close(l.compressCh)
+		l.compressCh = nil
+	}
+	compressDone := l.compressDone
+	err = l.close()
+	sinks := l.sinks
+	l.sinks = nil
+	l.lock.Unlock()
+
+	// let a running compression finish its file
+	if compressDone != nil {
+		select {
+		case <-compressDone:
+		case <-ctx.Done():
+		}
+	}
+
+	for i := 0; i < len(sinks); i++ {
+		if serr := sinks[i].Close(ctx); serr != nil && err == nil {
+			err = serr
+		}
+	}
+
+	return
 }
 
 type MailSender struct {
@@ -286,12 +688,63 @@ type MailSender struct {
 	outDir        string
 	file          *os.File
 	lock          sync.RWMutex
+	saveLock      sync.Mutex
 	saveTime      time.Time
 	curSize       int64
+	level         int32
+	maxLevel      int32
+
+	dupLock  sync.Mutex
+	dups     map[string]*dupInfo
+	dupOrder []*dupInfo
+
+	maxPerHour      int64
+	sent            []time.Time
+	suppressed      int
+	suppressedFiles int
+	suppressedSince time.Time
+
+	deadDir           string
+	retry             map[string]*spoolState
+	maxAttempts       int64
+	maxAge            int64
+	maxSpoolSize      int64
+	spoolDropped      int
+	spoolDroppedBytes int64
+	deadFiles         int
+
+	sendPeriod int64
+	wake       chan struct{}
+	sendLock   sync.Mutex
+	stop       chan struct{}
+	stopOnce   sync.Once
+	wg         sync.WaitGroup
+	closed     bool
+
+	digest *DigestOptions
+	// deliver replaces email, see WebhookSender
+	deliver func(files []string) error
 }
 
 func NewMailSender(prefix string, servers []MailServer, rcpts []string, subj string, dir string, maxMsgSize int, sendMsgPeriod time.Duration) (s *MailSender, err error) {
 
+	if s, err = newSpool(prefix, dir, maxMsgSize, sendMsgPeriod); err != nil {
+		return
+	}
+
+	s.servers = servers
+	s.rcpts = rcpts
+	s.subj = subj
+
+	s.start()
+
+	return
+}
+
+// newSpool creates the spool directories and the first batch file,
+// the background goroutines are started by start.
+func newSpool(prefix string, dir string, maxMsgSize int, sendMsgPeriod time.Duration) (s *MailSender, err error) {
+
 	if len(dir) == 0 {
 		return nil, errors.New("dir is not set")
 	}
@@ -310,24 +763,29 @@ func NewMailSender(prefix string, servers []MailServer, rcpts []string, subj str
 
 	s = &MailSender{
 		prefix:        prefix,
-		servers:       servers,
-		rcpts:         rcpts,
-		subj:          subj,
 		maxMsgSize:    int64(maxMsgSize),
 		sendMsgPeriod: sendMsgPeriod,
 		last:          filepath.Join(dir, "last"),
+		deadDir:       filepath.Join(dir, "dead"),
+		sendPeriod:    int64(cMailSendPeriod),
+		wake:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
 		tmpDir:        tmpDir,
 		outDir:        outDir,
+		maxLevel:      int32(LevelFatal),
 	}
 
 	if err = s.save(); err != nil {
-		return
+		return nil, err
 	}
 
+	return
+}
+
+func (s *MailSender) start() {
+	s.wg.Add(2)
 	go s.checkSave()
 	go s.sendFiles()
-
-	return
 }
 
 func (s *MailSender) move2Out(curFileName string) (err error) {
@@ -361,6 +819,10 @@ func (s *MailSender) move2Out(curFileName string) (err error) {
 
 func (s *MailSender) save() error {
 
+	// SendNow may save from another goroutine
+	s.saveLock.Lock()
+	defer s.saveLock.Unlock()
+
 	// create new file...
 	newCurFileName := s.prefix + strconv.FormatInt(time.Now().UnixNano(), 10) + ".txt"
 	newFile, err := os.Create(filepath.Join(s.tmpDir, newCurFileName))
@@ -372,11 +834,12 @@ func (s *MailSender) save() error {
 	s.lock.Lock()
 	// close old...
 	if s.file != nil {
+		s.writeDups(s.file)
 		s.file.Close()
 	}
 	s.file = newFile
 
-	s.curSize = 0
+	atomic.StoreInt64(&s.curSize, 0)
 	s.saveTime = time.Now().Add(s.sendMsgPeriod)
 	s.lock.Unlock()
 
@@ -385,71 +848,144 @@ func (s *MailSender) save() error {
 
 func (s *MailSender) checkSave() {
 
+	defer s.wg.Done()
+
+	// a sendMsgPeriod under a second is honoured
+	poll := 1 * time.Second
+	if s.sendMsgPeriod > 0 && s.sendMsgPeriod < poll {
+		poll = s.sendMsgPeriod
+	}
+
 	for {
 		curSize := atomic.LoadInt64(&s.curSize)
 
-		if curSize > 0 && (curSize >= s.maxMsgSize || s.saveTime.Before(time.Now())) {
+		s.lock.RLock()
+		saveTime := s.saveTime
+		s.lock.RUnlock()
+
+		if curSize > 0 && (curSize >= s.maxMsgSize || saveTime.Before(time.Now())) {
 			s.save()
 		}
 
-		time.Sleep(1 * time.Second)
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(poll):
+		}
 	}
 }
 
 func (s *MailSender) sendFiles() {
-	for {
 
-		time.Sleep(1 * time.Minute)
+	defer s.wg.Done()
+
+	for {
+		if !s.waitSend() {
+			return
+		}
 
 		if _, err := os.Stat(s.last); err == nil {
-			time.Sleep(1 * time.Minute)
+			if !s.waitSend() {
+				return
+			}
 			s.delLast()
 		}
 
-		fs, err := ioutil.ReadDir(s.outDir)
-		if err != nil {
-			log.Println(err.Error())
-			return
-		}
+		s.sendLock.Lock()
+		s.sendOut(false, false)
+		s.sendLock.Unlock()
+	}
+}
 
-		var files sort.StringSlice
-		for i := 0; i < len(fs); i++ {
-			fileName := fs[i].Name()
+// sendOut sends one batch of up to 10 files from the out directory and reports
+// whether something was sent. With force the retry delays are ignored, with
+// urgent the hourly cap too. It is called with s.sendLock held.
+func (s *MailSender) sendOut(force bool, urgent bool) (sent bool, err error) {
 
-			if !fs[i].IsDir() && len(fileName) >= len(s.prefix) && fileName[:len(s.prefix)] == s.prefix && fileName[len(fileName)-4:] == ".txt" {
-				files = append(files, fileName)
-			}
-		}
-		if len(files) == 0 {
-			continue
+	fs, err := ioutil.ReadDir(s.outDir)
+	if err != nil {
+		log.Println(err.Error())
+		return false, err
+	}
+
+	var infos []os.FileInfo
+	for i := 0; i < len(fs); i++ {
+		fileName := fs[i].Name()
+
+		if !fs[i].IsDir() && len(fileName) >= len(s.prefix) && fileName[:len(s.prefix)] == s.prefix && fileName[len(fileName)-4:] == ".txt" {
+			infos = append(infos, fs[i])
 		}
+	}
 
-		files.Sort()
-		if len(files) == 1 {
-			if err = s.sendText(files[0]); err != nil {
-				log.Println(err.Error())
-			} else {
-				if err := os.Remove(filepath.Join(s.outDir, files[0])); err != nil {
-					log.Println(err.Error())
-				}
-			}
-		} else {
-			cnt := len(files)
-			if cnt > 10 {
-				cnt = 10
-				files = files[:10]
-			}
+	// ReadDir sorts by name, so the oldest files go first
+	files := s.checkSpool(infos, force)
+	if len(files) == 0 {
+		return false, nil
+	}
 
-			if err := s.sendAttach(files, cnt); err != nil {
-				log.Println(err.Error())
-			} else {
-				for j := 0; j < cnt; j++ {
-					if err := os.Remove(filepath.Join(s.outDir, files[j])); err != nil {
-						log.Println(err.Error())
-					}
-				}
-			}
+	cnt := len(files)
+	if cnt > 10 {
+		cnt = 10
+		files = files[:10]
+	}
+
+	if !urgent && !s.allowSend(time.Now()) {
+		s.suppress(files)
+		return false, nil
+	}
+
+	if s.deliver != nil {
+		err = s.deliver(files)
+	} else if s.digest != nil {
+		err = s.sendDigest(files)
+	} else if cnt == 1 {
+		err = s.sendText(files[0])
+	} else {
+		err = s.sendAttach(files, cnt)
+	}
 
+	if err != nil {
+		log.Println(err.Error())
+		s.sendFailed(files)
+		return false, err
+	}
+
+	s.markSent()
+	s.sendDone(files)
+
+	return true, nil
+}
+
+// SetSendPeriod changes how often the out directory is sent, one minute by default.
+// The same period is the first retry delay of a failed send.
+func (s *MailSender) SetSendPeriod(period time.Duration) {
+	if period <= 0 {
+		period = cMailSendPeriod
+	}
+	atomic.StoreInt64(&s.sendPeriod, int64(period))
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *MailSender) getSendPeriod() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.sendPeriod))
+}
+
+// waitSend sleeps for the send period, restarting when it is changed.
+// It returns false when the sender is closed.
+func (s *MailSender) waitSend() bool {
+	for {
+		t := time.NewTimer(s.getSendPeriod())
+		select {
+		case <-s.stop:
+			t.Stop()
+			return false
+		case <-s.wake:
+			t.Stop()
+		case <-t.C:
+			return true
 		}
 	}
 }
@@ -477,7 +1013,7 @@ func (s *MailSender) sendAttach(files []string, cnt int) (err error) {
 
 	m.SetHeader("To", s.rcpts...)
 	m.SetHeader("Subject", s.subj)
-	m.SetBody("text/plian", "Logs in attachment ("+time.Now().UTC().Format("2006-01-02 15:04:05")+")\n")
+	m.SetBody("text/plain", s.sendNote()+"Logs in attachment ("+time.Now().UTC().Format("2006-01-02 15:04:05")+")\n")
 
 	if cnt > len(files) {
 		cnt = len(files)
@@ -489,7 +1025,7 @@ func (s *MailSender) sendAttach(files []string, cnt int) (err error) {
 
 	for i := 0; i < len(s.servers); i++ {
 		m.SetHeader("From", s.servers[i].Sender)
-		if err = gomail.NewDialer(s.servers[i].Host, s.servers[i].Port, s.servers[i].UserName, s.servers[i].Password).DialAndSend(m); err == nil {
+		if err = s.servers[i].send(m); err == nil {
 			break
 		}
 	}
@@ -510,22 +1046,138 @@ func (s *MailSender) sendText(fileName string) (err error) {
 
 	m.SetHeader("To", s.rcpts...)
 	m.SetHeader("Subject", s.subj)
-	m.SetBody("text/plain", "Log ("+time.Now().UTC().Format("2006-01-02 15:04:05")+"):\n"+string(file))
+	m.SetBody("text/plain", s.sendNote()+"Log ("+time.Now().UTC().Format("2006-01-02 15:04:05")+"):\n"+string(file))
 
 	for i := 0; i < len(s.servers); i++ {
 		m.SetHeader("From", s.servers[i].Sender)
-		if err = gomail.NewDialer(s.servers[i].Host, s.servers[i].Port, s.servers[i].UserName, s.servers[i].Password).DialAndSend(m); err == nil {
+		if err = s.servers[i].send(m); err == nil {
 			break
 		}
 	}
 	return
 }
 
+// SetLevel sets the minimum level of entries included in alerts.
+func (s *MailSender) SetLevel(level Level) {
+	atomic.StoreInt32(&s.level, int32(level))
+}
+
+func (s *MailSender) GetLevel() Level {
+	return Level(atomic.LoadInt32(&s.level))
+}
+
+// SetMaxLevel sets the maximum level of entries included in alerts,
+// so that higher levels can be routed to another MailSender.
+func (s *MailSender) SetMaxLevel(level Level) {
+	atomic.StoreInt32(&s.maxLevel, int32(level))
+}
+
+func (s *MailSender) Enabled(level Level) bool {
+	return level >= s.GetLevel() && level <= Level(atomic.LoadInt32(&s.maxLevel))
+}
+
+// WriteEntry adds the entry to the current batch. Repeats of a message
+// are counted and summarized when the batch is closed.
+func (s *MailSender) WriteEntry(e *Entry) (err error) {
+	if s.isDup(e) {
+		return nil
+	}
+	_, err = s.Write(e.Line)
+	return
+}
+
+// Close stops the background goroutines, moves the current batch to the out
+// directory and tries to send everything before ctx is done. Whatever is not
+// sent stays in the spool for the next MailSender with the same dir.
+func (s *MailSender) Close(ctx context.Context) (err error) {
+
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.lock.Lock()
+	if s.file != nil {
+		s.writeDups(s.file)
+		s.file.Close()
+		s.file = nil
+	}
+	s.closed = true
+	s.lock.Unlock()
+
+	if err = s.move2Out(""); err != nil {
+		return
+	}
+
+	return s.sendAll(ctx, false)
+}
+
+// SendNow closes the current batch and sends the spool right away, without
+// waiting for sendMsgPeriod or retry delays. It returns when everything is
+// sent, a send fails or ctx is done.
+func (s *MailSender) SendNow(ctx context.Context) (err error) {
+	return s.sendNow(ctx, false)
+}
+
+// sendNow is SendNow, urgent sends also ignore the hourly cap (the alert of a panic).
+func (s *MailSender) sendNow(ctx context.Context, urgent bool) (err error) {
+
+	s.lock.RLock()
+	closed := s.closed
+	s.lock.RUnlock()
+
+	// Close has sent everything already
+	if closed {
+		return nil
+	}
+
+	if atomic.LoadInt64(&s.curSize) > 0 {
+		if err = s.save(); err != nil {
+			return
+		}
+	}
+
+	return s.sendAll(ctx, urgent)
+}
+
+func (s *MailSender) sendAll(ctx context.Context, urgent bool) error {
+
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	for ctx.Err() == nil {
+		sent, err := s.sendOut(true, urgent)
+		if err != nil {
+			return err
+		}
+		if !sent {
+			return nil
+		}
+	}
+
+	return ctx.Err()
+}
+
 func (s *MailSender) Write(p []byte) (n int, err error) {
 
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 
+	if s.closed {
+		return 0, errors.New("MailSender is closed")
+	}
+
 	// write to file
 	n, err = s.file.Write(p)
 	if n > 0 {
@@ -0,0 +1,504 @@
+package nicklog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	cFollowPeriod   = 250 * time.Millisecond
+	cReaderBufSize  = 64 * 1024
+	cMaxEntryLength = 16 * 1024 * 1024
+)
+
+var errWait = errors.New("wait for more data")
+
+// ArchiveFile is a rotated file in arcDir. It holds the entries written
+// after From (zero for the oldest archive) and up to To, its rotation time.
+type ArchiveFile struct {
+	Path       string
+	From       time.Time
+	To         time.Time
+	Size       int64
+	Compressed bool
+}
+
+// Archives lists the archives holding entries between from and to, the oldest
+// first. A zero from or to leaves that end open.
+func (l *Logger) Archives(from time.Time, to time.Time) (files []ArchiveFile, err error) {
+
+	arcs, err := l.listArchives()
+	if err != nil {
+		return nil, err
+	}
+
+	var prev time.Time
+	for i := 0; i < len(arcs); i++ {
+		a := ArchiveFile{
+			Path:       filepath.Join(l.arcDir, arcs[i].name),
+			From:       prev,
+			To:         arcs[i].time,
+			Size:       arcs[i].size,
+			Compressed: strings.HasSuffix(arcs[i].name, cGzipExt),
+		}
+		prev = a.To
+
+		if (to.IsZero() || !a.From.After(to)) && (from.IsZero() || !a.To.Before(from)) {
+			files = append(files, a)
+		}
+	}
+
+	return
+}
+
+// Reader streams entries from archives and the live file in order.
+// Lines that don't start with a timestamp (or a JSON object), e.g. stack
+// traces, belong to the entry before them.
+type Reader struct {
+	l    *Logger
+	from time.Time
+	to   time.Time
+	live string
+
+	paths  []string
+	file   *os.File
+	gz     *gzip.Reader
+	br     *bufio.Reader
+	isLive bool
+
+	follow   bool
+	ctx      context.Context
+	draining bool
+	checked  time.Time
+
+	partial []byte
+	pending []byte
+	buf     []byte
+	e       Entry
+}
+
+// NewReader returns a Reader of the entries between from and to, zero
+// meaning an open end. The live file is read up to its current end.
+func (l *Logger) NewReader(from time.Time, to time.Time) (r *Reader, err error) {
+
+	arcs, err := l.Archives(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	r = &Reader{
+		l:    l,
+		from: from,
+		to:   to,
+		live: filepath.Join(l.dir, l.fileName),
+	}
+
+	for i := 0; i < len(arcs); i++ {
+		r.paths = append(r.paths, arcs[i].Path)
+	}
+
+	if len(arcs) == 0 || to.IsZero() || to.After(arcs[len(arcs)-1].To) {
+		r.paths = append(r.paths, r.live)
+	}
+
+	return r, nil
+}
+
+// Follow returns a Reader of the live file that starts with its last tail
+// entries and then waits for new ones, like tail -f. It keeps following the
+// file after a rotation. Next returns ctx.Err() when ctx is done.
+func (l *Logger) Follow(ctx context.Context, tail int) (r *Reader, err error) {
+
+	r = &Reader{
+		l:       l,
+		live:    filepath.Join(l.dir, l.fileName),
+		follow:  true,
+		ctx:     ctx,
+		checked: time.Now(),
+	}
+	r.paths = []string{r.live}
+
+	if err = r.openNext(); err != nil {
+		if os.IsNotExist(err) {
+			// read it from the start once it is created
+			return r, nil
+		}
+		return nil, err
+	}
+
+	offset, err := tailOffset(r.file, tail)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	if _, err = r.file.Seek(offset, io.SeekStart); err != nil {
+		r.Close()
+		return nil, err
+	}
+	r.br.Reset(r.file)
+
+	return r, nil
+}
+
+// tailOffset returns the offset of the last n entries of file.
+func tailOffset(file *os.File, n int) (offset int64, err error) {
+
+	if n <= 0 {
+		return file.Seek(0, io.SeekEnd)
+	}
+
+	starts := make([]int64, 0, n)
+	br := bufio.NewReaderSize(file, cReaderBufSize)
+
+	var pos int64
+	lineStart := true
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if lineStart {
+			if _, _, ok := parseEntryStart(chunk); ok {
+				if len(starts) == n {
+					starts = starts[1:]
+				}
+				starts = append(starts, pos)
+			}
+		}
+		pos += int64(len(chunk))
+		lineStart = err == nil
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return 0, err
+		}
+	}
+
+	if len(starts) == 0 {
+		return pos, nil
+	}
+
+	return starts[0], nil
+}
+
+// Next returns the next entry, or io.EOF at the end. Time, Level and Line are
+// set, Line holds the entry as written and is valid until the next call.
+func (r *Reader) Next() (*Entry, error) {
+
+	for {
+		e, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		if !r.from.IsZero() && e.Time.Before(r.from) {
+			continue
+		}
+		if !r.to.IsZero() && e.Time.After(r.to) {
+			continue
+		}
+		return e, nil
+	}
+}
+
+func (r *Reader) next() (*Entry, error) {
+
+	r.buf = r.buf[:0]
+	started := false
+
+	if len(r.pending) > 0 {
+		r.buf = append(r.buf, r.pending...)
+		r.pending = r.pending[:0]
+		started = true
+	}
+
+	for {
+		line, eof, err := r.readLine()
+
+		if err == errWait {
+			if started {
+				return r.entry(), nil
+			}
+			select {
+			case <-r.ctx.Done():
+				return nil, r.ctx.Err()
+			case <-time.After(cFollowPeriod):
+			}
+			continue
+		}
+		if err != nil {
+			if started && err == io.EOF {
+				return r.entry(), nil
+			}
+			return nil, err
+		}
+
+		if eof {
+			// entries never span files
+			if started {
+				return r.entry(), nil
+			}
+			continue
+		}
+
+		if _, _, ok := parseEntryStart(line); ok {
+			if started {
+				r.pending = append(r.pending, line...)
+				return r.entry(), nil
+			}
+			started = true
+		} else if !started {
+			// continuation of an entry before the start of the file or the tail
+			continue
+		}
+
+		if len(r.buf)+len(line) <= cMaxEntryLength {
+			r.buf = append(r.buf, line...)
+		}
+	}
+}
+
+func (r *Reader) entry() *Entry {
+
+	r.e = Entry{Line: r.buf}
+	r.e.Time, r.e.Level, _ = parseEntryStart(r.buf)
+
+	return &r.e
+}
+
+// readLine returns the next complete line, valid until the next call.
+// eof reports the end of a file, io.EOF the end of all of them and
+// errWait that the followed file has no new data yet.
+func (r *Reader) readLine() (line []byte, eof bool, err error) {
+
+	r.partial = r.partial[:0]
+
+	for {
+		if r.br == nil {
+			if len(r.paths) == 0 {
+				return nil, false, io.EOF
+			}
+			if err = r.openNext(); err != nil {
+				if os.IsNotExist(err) {
+					if r.follow && r.paths[0] == r.live {
+						return nil, false, errWait
+					}
+					// deleted by retention meanwhile
+					r.paths = r.paths[1:]
+					continue
+				}
+				return nil, false, err
+			}
+		}
+
+		chunk, err := r.br.ReadSlice('\n')
+		r.partial = append(r.partial, chunk...)
+
+		switch err {
+		case nil:
+			return r.partial, false, nil
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+		default:
+			return nil, false, err
+		}
+
+		if r.follow && r.isLive {
+			if r.draining {
+				r.draining = false
+				r.paths = append(r.rotatedSince(), r.live)
+				r.closeFile()
+				return nil, true, nil
+			}
+			now := time.Now()
+			if r.replaced() {
+				// read what was written before the rotation, then switch
+				r.draining = true
+				continue
+			}
+			r.checked = now
+			// an incomplete line is read again with the rest of it
+			if len(r.partial) > 0 {
+				if _, err := r.file.Seek(-int64(len(r.partial)), io.SeekCurrent); err != nil {
+					return nil, false, err
+				}
+				r.br.Reset(r.file)
+			}
+			return nil, false, errWait
+		}
+
+		if len(r.partial) > 0 {
+			// the last line has no line break, the next read returns eof
+			return r.partial, false, nil
+		}
+
+		r.closeFile()
+		return nil, true, nil
+	}
+}
+
+// openNext opens r.paths[0]. An archive compressed meanwhile is read as .gz.
+func (r *Reader) openNext() (err error) {
+
+	path := r.paths[0]
+
+	file, err := os.Open(path)
+	if err != nil && os.IsNotExist(err) && path != r.live && !strings.HasSuffix(path, cGzipExt) {
+		path += cGzipExt
+		file, err = os.Open(path)
+	}
+	if err != nil {
+		return
+	}
+
+	var src io.Reader = file
+	if strings.HasSuffix(path, cGzipExt) {
+		if r.gz, err = gzip.NewReader(file); err != nil {
+			file.Close()
+			return
+		}
+		src = r.gz
+	}
+
+	r.file = file
+	r.isLive = r.paths[0] == r.live
+	r.paths = r.paths[1:]
+
+	if r.br == nil {
+		r.br = bufio.NewReaderSize(src, cReaderBufSize)
+	} else {
+		r.br.Reset(src)
+	}
+
+	return nil
+}
+
+// rotatedSince returns the archives rotated after the followed file,
+// when the logger rotated more than once since the last check.
+func (r *Reader) rotatedSince() (paths []string) {
+
+	// archive names have milliseconds, truncated
+	arcs, err := r.l.Archives(r.checked.Truncate(time.Millisecond), time.Time{})
+	if err != nil || len(arcs) == 0 {
+		return nil
+	}
+
+	// the first one is the file just read, unless it was moved elsewhere
+	if cur, err := r.file.Stat(); err == nil {
+		if fileInfo, err := os.Stat(arcs[0].Path); err != nil || os.SameFile(cur, fileInfo) || arcs[0].Compressed {
+			arcs = arcs[1:]
+		}
+	}
+
+	for i := 0; i < len(arcs); i++ {
+		paths = append(paths, arcs[i].Path)
+	}
+
+	return
+}
+
+// replaced reports whether the followed file was rotated or truncated.
+func (r *Reader) replaced() bool {
+
+	fileInfo, err := os.Stat(r.live)
+	if err != nil {
+		return false
+	}
+
+	cur, err := r.file.Stat()
+	if err != nil {
+		return false
+	}
+
+	if !os.SameFile(cur, fileInfo) {
+		return true
+	}
+
+	pos, err := r.file.Seek(0, io.SeekCurrent)
+
+	return err == nil && fileInfo.Size() < pos
+}
+
+func (r *Reader) closeFile() {
+
+	if r.gz != nil {
+		r.gz.Close()
+		r.gz = nil
+	}
+
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+
+	r.br = nil
+}
+
+func (r *Reader) Close() error {
+	r.closeFile()
+	r.paths = nil
+	return nil
+}
+
+// parseEntryStart recognizes the first line of a text or JSON entry.
+// Raw lines without a level are LevelInfo.
+func parseEntryStart(line []byte) (t time.Time, level Level, ok bool) {
+
+	level = LevelInfo
+
+	if len(line) > 0 && line[0] == '{' {
+		var v struct {
+			Time  string `json:"time"`
+			Level string `json:"level"`
+		}
+		if i := indexLineEnd(line); json.Unmarshal(line[:i], &v) != nil {
+			return
+		}
+		if t, err := time.Parse(cJSONTimeFormat, v.Time); err == nil {
+			if lv, ok := levelToken(v.Level); ok {
+				level = lv
+			}
+			return t, level, true
+		}
+		return
+	}
+
+	if len(line) < len(cLineTimeFormat) {
+		return
+	}
+
+	t, err := time.ParseInLocation(cLineTimeFormat, string(line[:len(cLineTimeFormat)]), time.Local)
+	if err != nil {
+		return
+	}
+
+	rest := line[len(cLineTimeFormat):]
+	if len(rest) > 0 && rest[0] == ' ' {
+		rest = rest[1:]
+		end := 0
+		for end < len(rest) && rest[end] != ' ' && rest[end] != '\n' {
+			end++
+		}
+		if lv, ok := levelToken(string(rest[:end])); ok {
+			level = lv
+		}
+	}
+
+	return t, level, true
+}
+
+func indexLineEnd(line []byte) int {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\n' {
+			return i
+		}
+	}
+	return len(line)
+}
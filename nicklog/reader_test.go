@@ -0,0 +1,27 @@
+package nicklog
+
+import "testing"
+
+func TestParseEntryStart(t *testing.T) {
+
+	tests := []struct {
+		line  string
+		level Level
+		ok    bool
+	}{
+		{"2024-01-01 00:00:00 WARN disk\n", LevelWarn, true},
+		{"2024-01-01 00:00:00 warning disk\n", LevelInfo, true},
+		{"2024-01-01 00:00:00 Error while reading\n", LevelInfo, true},
+		{"2024-01-01 00:00:00 FATAL\n", LevelFatal, true},
+		{`{"time":"2024-01-01T00:00:00.000Z","level":"ERROR","msg":"x"}` + "\n", LevelError, true},
+		{`{"time":"2024-01-01T00:00:00.000Z","level":"error","msg":"x"}` + "\n", LevelInfo, true},
+		{"\tmain.main()\n", LevelInfo, false},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		_, level, ok := parseEntryStart([]byte(tests[i].line))
+		if level != tests[i].level || ok != tests[i].ok {
+			t.Errorf("%q: got %v %v", tests[i].line, level, ok)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package nicklog
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// SetPanicReturn chooses whether Recover returns after logging a panic
+// or panics again with the same value (the default).
+func (l *Logger) SetPanicReturn(ret bool) {
+	atomic.StoreInt32(&l.panicReturn, boolInt32(ret))
+}
+
+// Recover logs a panic of the current goroutine at LevelFatal with its stack,
+// then sends the alert immediately. It must be deferred directly:
+//
+//	defer l.Recover()
+func (l *Logger) Recover() {
+	if r := recover(); r != nil {
+		l.handlePanic(r)
+	}
+}
+
+// Go runs f in a new goroutine guarded by Recover.
+func (l *Logger) Go(f func()) {
+	go func() {
+		defer l.Recover()
+		f()
+	}()
+}
+
+func (l *Logger) handlePanic(r interface{}) {
+
+	// a panic is logged whatever the level of the logger
+	e := Entry{
+		Time:   time.Now(),
+		Level:  LevelFatal,
+		Msg:    "panic: " + fmt.Sprint(r),
+		Fields: l.fields,
+		Stack:  string(debug.Stack()),
+	}
+
+	// written after the queued entries, but never dropped by a full async buffer
+	l.Flush()
+
+	buf := getBuf()
+	*buf = appendEntry(*buf, l.GetFormat(), &e)
+	e.Line = *buf
+	l.write(&e)
+	e.Line = nil
+	putBuf(buf)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cCloseTimeout)
+	l.sendNow(ctx)
+	cancel()
+
+	if atomic.LoadInt32(&l.panicReturn) == 0 {
+		panic(r)
+	}
+}
+
+// sendNow flushes every sink that spools alerts (MailSender, WebhookSender or
+// any sink with SendNow), the hourly cap doesn't hold back the alert of a panic.
+func (l *Logger) sendNow(ctx context.Context) {
+
+	l.lock.Lock()
+	sinks := l.sinks
+	l.lock.Unlock()
+
+	for i := 0; i < len(sinks); i++ {
+		var err error
+		switch s := sinks[i].(type) {
+		case interface {
+			sendNow(ctx context.Context, urgent bool) error
+		}:
+			err = s.sendNow(ctx, true)
+		case interface {
+			SendNow(ctx context.Context) error
+		}:
+			err = s.SendNow(ctx)
+		}
+		if err != nil {
+			l.lock.Lock()
+			l.reportError(err)
+			l.lock.Unlock()
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package nicklog
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nicklib/nicklog/smtptest"
+)
+
+func panicRecovered(l *Logger) {
+	defer l.Recover()
+	panic("boom")
+}
+
+func TestRecoverFullAsyncBuffer(t *testing.T) {
+
+	dir := t.TempDir()
+	l, err := NewLoggerFromConfig(Config{
+		Dir:             dir,
+		FileName:        "app.log",
+		MaxSize:         1024,
+		MaxFiles:        2,
+		Async:           true,
+		AsyncBufferSize: 2,
+		AsyncOverflow:   OverflowDrop,
+		PanicReturn:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the writer goroutine is stuck until the lock is released
+	l.lock.Lock()
+	for i := 0; i < 10; i++ {
+		l.Info("filler")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		panicRecovered(l)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	l.lock.Unlock()
+	<-done
+	l.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, "app.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("FATAL panic: boom")) {
+		t.Errorf("panic entry dropped:\n%s", data)
+	}
+}
+
+func TestRecoverMailCap(t *testing.T) {
+
+	srv := smtptest.NewServer()
+	defer srv.Close()
+
+	l, err := NewLoggerFromConfig(Config{
+		Dir:            t.TempDir(),
+		FileName:       "app.log",
+		MaxSize:        1024,
+		MaxFiles:       2,
+		MailServers:    []MailServer{testMailServer(srv)},
+		MailRcpts:      []string{"ops@example.com"},
+		MailLevel:      LevelError,
+		MailMaxPerHour: 1,
+		PanicReturn:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx := context.Background()
+	s := l.mailSenders[0]
+
+	// the hourly cap is used up
+	l.Error("first")
+	if err := s.SendNow(ctx); err != nil {
+		t.Fatal(err)
+	}
+	l.Error("second")
+	if err := s.SendNow(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.Messages()); n != 1 {
+		t.Fatalf("got %d messages before the panic, want 1", n)
+	}
+
+	panicRecovered(l)
+
+	msgs := srv.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want the panic alert too", len(msgs))
+	}
+	if !bytes.Contains(msgs[1].Data, []byte("panic: boom")) {
+		t.Errorf("alert without the panic:\n%s", msgs[1].Data)
+	}
+}
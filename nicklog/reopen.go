@@ -0,0 +1,118 @@
+package nicklog
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const cFileCheckPeriod = 1 * time.Second
+
+// Reopen closes the current file and opens dir/fileName again, creating it
+// if needed. It is meant for external rotation like logrotate, which moves
+// the file away and signals the process.
+func (l *Logger) Reopen() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.reopen()
+}
+
+// reopen is called under l.lock. On failure the logger writes to stderr
+// and checkRotate keeps trying to open the file.
+func (l *Logger) reopen() (err error) {
+
+	if err := l.close(); err != nil {
+		l.reportError(err)
+	}
+	l.file = nil
+
+	if err = l.openFile(); err != nil {
+		return errors.New("Cannot reopen: " + err.Error())
+	}
+	l.rotateRetry = time.Time{}
+
+	return nil
+}
+
+// ReopenOnSignal calls Reopen whenever one of sigs arrives, SIGHUP by default.
+// Errors go to the error handler. It stops on Close.
+func (l *Logger) ReopenOnSignal(sigs ...os.Signal) {
+
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	l.lock.Lock()
+	if l.reopenStop == nil {
+		l.reopenStop = make(chan struct{})
+	}
+	stop := l.reopenStop
+	l.lock.Unlock()
+
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ch:
+				if err := l.Reopen(); err != nil {
+					l.lock.Lock()
+					l.reportError(err)
+					l.lock.Unlock()
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// SetFileCheckPeriod sets how often dir/fileName is checked for being deleted
+// or replaced by another file, which is then reopened. Zero disables the check.
+func (l *Logger) SetFileCheckPeriod(period time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.fileCheckPeriod = period
+	l.nextFileCheck = time.Time{}
+}
+
+// checkFile is called under l.lock before each write, the stat calls
+// are done at most once per fileCheckPeriod.
+func (l *Logger) checkFile() {
+
+	if l.fileCheckPeriod <= 0 || l.file == nil {
+		return
+	}
+
+	now := time.Now()
+	if now.Before(l.nextFileCheck) {
+		return
+	}
+	l.nextFileCheck = now.Add(l.fileCheckPeriod)
+
+	cur, err := l.file.Stat()
+	if err != nil {
+		return
+	}
+
+	fileInfo, err := os.Stat(filepath.Join(l.dir, l.fileName))
+	if err == nil && os.SameFile(cur, fileInfo) {
+		// truncated in place, e.g. logrotate copytruncate
+		if fileInfo.Size() < l.size {
+			l.size = fileInfo.Size()
+		}
+		return
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	if err = l.reopen(); err != nil {
+		l.reportError(err)
+	}
+}
@@ -0,0 +1,155 @@
+package nicklog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	DeleteReasonCount = "count"
+	DeleteReasonAge   = "age"
+	DeleteReasonSize  = "size"
+)
+
+type archiveInfo struct {
+	name string
+	time time.Time
+	size int64
+	// dup is the plain file left next to name.gz by an interrupted compression
+	dup string
+}
+
+// SetRetention limits archives in arcDir by age and by their total size,
+// in addition to maxFiles. Zero disables the corresponding rule.
+func (l *Logger) SetRetention(maxAge time.Duration, maxArcSize int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.maxAge = maxAge
+	l.maxArcSize = maxArcSize
+}
+
+// SetDeleteHandler sets f to be called for every deleted archive. The handler
+// runs with the logger locked, so it must not write to the same Logger.
+// Without a handler the deletion is written to the log file itself.
+func (l *Logger) SetDeleteHandler(f func(fileName string, reason string)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.onDelete = f
+}
+
+// listArchives returns archives of this logger in arcDir, the oldest first.
+// An archive found both plain and compressed is listed once, as the .gz.
+func (l *Logger) listArchives() (arcs []archiveInfo, err error) {
+
+	files, err := ioutil.ReadDir(l.arcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(files); i++ {
+		if files[i].IsDir() {
+			continue
+		}
+		if t, ok := l.parseArcName(files[i].Name()); ok {
+			arcs = append(arcs, archiveInfo{name: files[i].Name(), time: t, size: files[i].Size()})
+		}
+	}
+
+	sort.Slice(arcs, func(i, j int) bool {
+		if arcs[i].time.Equal(arcs[j].time) {
+			return arcs[i].name < arcs[j].name
+		}
+		return arcs[i].time.Before(arcs[j].time)
+	})
+
+	// app_T.log sorts before app_T.log.gz
+	n := 0
+	for i := 0; i < len(arcs); i++ {
+		if n > 0 && arcs[i].time.Equal(arcs[n-1].time) {
+			arcs[i].dup = arcs[n-1].name
+			arcs[n-1] = arcs[i]
+			continue
+		}
+		arcs[n] = arcs[i]
+		n++
+	}
+
+	return arcs[:n], nil
+}
+
+// delOld deletes the oldest archives while any retention rule is violated.
+// It runs before a rotation, so one slot is left for the file being archived.
+func (l *Logger) delOld() (err error) {
+
+	// no compression may finish while archives are deleted
+	l.arcLock.Lock()
+	defer l.arcLock.Unlock()
+
+	arcs, err := l.listArchives()
+	if err != nil {
+		return
+	}
+
+	var total int64
+	for i := 0; i < len(arcs); i++ {
+		total += arcs[i].size
+	}
+
+	now := time.Now()
+	for len(arcs) > 0 {
+		reason := ""
+		switch {
+		case len(arcs) >= l.maxFiles-1:
+			reason = DeleteReasonCount
+		case l.maxAge > 0 && now.Sub(arcs[0].time) > l.maxAge:
+			reason = DeleteReasonAge
+		case l.maxArcSize > 0 && total > l.maxArcSize:
+			reason = DeleteReasonSize
+		default:
+			return nil
+		}
+
+		if err = os.Remove(filepath.Join(l.arcDir, arcs[0].name)); err != nil {
+			return
+		}
+		if len(arcs[0].dup) > 0 {
+			if err = os.Remove(filepath.Join(l.arcDir, arcs[0].dup)); err != nil && !os.IsNotExist(err) {
+				return
+			}
+		}
+		l.reportDelete(arcs[0].name, reason)
+
+		total -= arcs[0].size
+		arcs = arcs[1:]
+	}
+
+	return nil
+}
+
+func (l *Logger) reportDelete(fileName string, reason string) {
+
+	if l.onDelete != nil {
+		l.onDelete(fileName, reason)
+		return
+	}
+
+	if l.file == nil {
+		return
+	}
+
+	e := Entry{
+		Time:   time.Now(),
+		Level:  LevelInfo,
+		Msg:    "archive deleted",
+		Fields: []Field{String("file", fileName), String("reason", reason)},
+	}
+
+	buf := getBuf()
+	*buf = appendEntry(*buf, l.GetFormat(), &e)
+	n, _ := l.file.Write(*buf)
+	l.size += int64(n)
+	putBuf(buf)
+}
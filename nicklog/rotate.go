@@ -0,0 +1,108 @@
+package nicklog
+
+import (
+	"errors"
+	"time"
+)
+
+type RotateInterval int32
+
+const (
+	RotateNone RotateInterval = iota
+	RotateHourly
+	RotateDaily
+)
+
+// SetRotation adds rotation by wall-clock time to the size rule.
+// For RotateDaily at is the offset from midnight (e.g. 3*time.Hour),
+// for RotateHourly it is the offset from the start of the hour.
+// An empty file is never rotated by time.
+func (l *Logger) SetRotation(interval RotateInterval, at time.Duration) error {
+
+	switch interval {
+	case RotateNone:
+	case RotateHourly:
+		if at < 0 || at >= time.Hour {
+			return errors.New("rotation offset must be within an hour")
+		}
+	case RotateDaily:
+		if at < 0 || at >= 24*time.Hour {
+			return errors.New("rotation offset must be within a day")
+		}
+	default:
+		return errors.New("unknown rotation interval")
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.rotateInterval = interval
+	l.rotateAt = at
+	l.nextRotate = time.Time{}
+
+	if interval == RotateNone {
+		return nil
+	}
+
+	// a file left from an earlier run is rotated at the first Write
+	// if it has already crossed a boundary.
+	from := time.Now()
+	if l.file != nil && l.size > 0 {
+		if fileInfo, err := l.file.Stat(); err == nil {
+			from = fileInfo.ModTime()
+		}
+	}
+	l.nextRotate = l.nextRotateTime(from)
+
+	return nil
+}
+
+// needRotate is called under l.lock.
+func (l *Logger) needRotate(n int) bool {
+
+	if (l.size + int64(n)) > l.maxSize {
+		return true
+	}
+
+	if l.nextRotate.IsZero() {
+		return false
+	}
+
+	now := time.Now()
+	if now.Before(l.nextRotate) {
+		return false
+	}
+
+	if l.size == 0 {
+		l.nextRotate = l.nextRotateTime(now)
+		return false
+	}
+
+	return true
+}
+
+// nextRotateTime returns the first boundary strictly after t. The boundary is
+// a wall-clock time, so a daily rotation at 03:00 stays at 03:00 on DST days.
+func (l *Logger) nextRotateTime(t time.Time) (next time.Time) {
+
+	y, m, d := t.Date()
+	h := int(l.rotateAt / time.Hour)
+	min := int(l.rotateAt % time.Hour / time.Minute)
+	sec := int(l.rotateAt % time.Minute / time.Second)
+	nsec := int(l.rotateAt % time.Second)
+
+	switch l.rotateInterval {
+	case RotateHourly:
+		next = time.Date(y, m, d, t.Hour(), min, sec, nsec, t.Location())
+		if !next.After(t) {
+			next = time.Date(y, m, d, t.Hour()+1, min, sec, nsec, t.Location())
+		}
+	case RotateDaily:
+		next = time.Date(y, m, d, h, min, sec, nsec, t.Location())
+		if !next.After(t) {
+			next = time.Date(y, m, d+1, h, min, sec, nsec, t.Location())
+		}
+	}
+
+	return
+}
@@ -0,0 +1,47 @@
+package nicklog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotateNamedByBoundary(t *testing.T) {
+
+	dir := t.TempDir()
+	l, err := NewLoggerFromConfig(Config{
+		Dir:            dir,
+		FileName:       "app.log",
+		MaxSize:        1024,
+		MaxFiles:       5,
+		RotateInterval: RotateDaily,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Info("before midnight")
+
+	// the first write after the boundary comes hours later
+	boundary := time.Now().Add(-9 * time.Hour).Truncate(time.Millisecond)
+	l.lock.Lock()
+	l.nextRotate = boundary
+	l.lock.Unlock()
+
+	l.Info("after midnight")
+
+	want := "app_" + boundary.Format(cTimeFormat) + ".log"
+	if _, err := os.Stat(filepath.Join(dir, want)); err != nil {
+		t.Fatalf("archive not named by the boundary: %v", err)
+	}
+
+	arcs, err := l.Archives(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(arcs) != 1 || !arcs[0].To.Equal(boundary) {
+		t.Errorf("archives %+v, want one up to %v", arcs, boundary)
+	}
+}
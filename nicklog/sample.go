@@ -0,0 +1,169 @@
+package nicklog
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	cDefSampleInterval = 1 * time.Second
+	cMaxSampleKeys     = 10000
+)
+
+// Sampling limits floods of similar entries: per key and interval the first
+// First entries are written, then every Thereafter-th one (none if 0).
+// The key is the format (Infof...), the message (Infow..., slog) or the one set
+// by WithSampleKey. At the end of the interval a "suppressed K similar entries"
+// line is written for every key with dropped entries.
+// Printf is sampled by its format, Print and Println are not.
+type Sampling struct {
+	Interval   time.Duration
+	First      int
+	Thereafter int
+}
+
+type sampleCount struct {
+	level   Level
+	key     string
+	n       int
+	dropped int
+}
+
+type sampler struct {
+	l          *Logger
+	first      int
+	thereafter int
+
+	lock   sync.Mutex
+	counts map[string]*sampleCount
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// SetSampling starts sampling with cfg, nil turns it off. Entries suppressed
+// by the previous sampler are reported first.
+func (l *Logger) SetSampling(cfg *Sampling) {
+
+	var s *sampler
+	if cfg != nil {
+		interval := cfg.Interval
+		if interval <= 0 {
+			interval = cDefSampleInterval
+		}
+		s = &sampler{
+			l:          &Logger{logCore: l.logCore},
+			first:      cfg.First,
+			thereafter: cfg.Thereafter,
+			counts:     make(map[string]*sampleCount),
+			stop:       make(chan struct{}),
+			done:       make(chan struct{}),
+		}
+		go s.run(interval)
+	}
+
+	if old := l.sampling.Swap(s); old != nil {
+		old.close()
+	}
+}
+
+// WithSampleKey returns a child logger whose entries are all sampled under key,
+// whatever their message.
+func (l *Logger) WithSampleKey(key string) *Logger {
+	return &Logger{
+		logCore:    l.logCore,
+		fields:     l.fields,
+		callerSkip: l.callerSkip,
+		sampleKey:  key,
+	}
+}
+
+// sample reports whether the entry is to be written.
+func (l *Logger) sample(level Level, key string) bool {
+
+	s := l.sampling.Load()
+	if s == nil {
+		return true
+	}
+
+	if len(l.sampleKey) > 0 {
+		key = l.sampleKey
+	}
+
+	return s.allow(level, key)
+}
+
+func (s *sampler) allow(level Level, key string) bool {
+
+	mapKey := level.String() + " " + key
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c := s.counts[mapKey]
+	if c == nil {
+		if len(s.counts) >= cMaxSampleKeys {
+			return true
+		}
+		c = &sampleCount{level: level, key: key}
+		s.counts[mapKey] = c
+	}
+
+	c.n++
+	if c.n <= s.first {
+		return true
+	}
+	if s.thereafter > 0 && (c.n-s.first)%s.thereafter == 0 {
+		return true
+	}
+
+	c.dropped++
+	return false
+}
+
+func (s *sampler) run(interval time.Duration) {
+
+	defer close(s.done)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			s.report()
+		case <-s.stop:
+			s.report()
+			return
+		}
+	}
+}
+
+// report starts a new interval and writes the suppressed counts of the last one,
+// at the level of the suppressed entries so that alerts see them too.
+func (s *sampler) report() {
+
+	s.lock.Lock()
+	counts := s.counts
+	s.counts = make(map[string]*sampleCount, len(counts))
+	s.lock.Unlock()
+
+	for _, c := range counts {
+		if c.dropped == 0 {
+			continue
+		}
+		e := Entry{
+			Time:   time.Now(),
+			Level:  c.level,
+			Msg:    "suppressed " + strconv.Itoa(c.dropped) + " similar entries",
+			Fields: []Field{String("key", c.key)},
+		}
+		s.l.writeEntry(&e)
+	}
+}
+
+func (s *sampler) close() {
+	close(s.stop)
+	<-s.done
+}
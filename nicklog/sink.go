@@ -0,0 +1,66 @@
+package nicklog
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Sink is a secondary destination of log entries. Logger writes to its sinks
+// after the file, with the logger locked, so WriteEntry must not block for long
+// and must not keep e.Line after returning.
+// In async mode e.Fields is nil, sinks use Time, Level, Msg, Caller and Line.
+type Sink interface {
+	WriteEntry(e *Entry) error
+	Enabled(level Level) bool
+	Close(ctx context.Context) error
+}
+
+// AddSink adds s to the fan-out list of the logger.
+func (l *Logger) AddSink(s Sink) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.sinks = append(l.sinks, s)
+}
+
+// WriterSink copies encoded lines to an io.Writer, e.g. os.Stderr. The write is
+// done under the logger lock, so w must not block: a network connection needs
+// a queue in front of it, like the one of SyslogSink.
+type WriterSink struct {
+	w     io.Writer
+	level int32
+	lock  sync.Mutex
+}
+
+func NewWriterSink(w io.Writer, level Level) *WriterSink {
+	return &WriterSink{
+		w:     w,
+		level: int32(level),
+	}
+}
+
+func (s *WriterSink) SetLevel(level Level) {
+	atomic.StoreInt32(&s.level, int32(level))
+}
+
+func (s *WriterSink) Enabled(level Level) bool {
+	return level >= Level(atomic.LoadInt32(&s.level))
+}
+
+func (s *WriterSink) WriteEntry(e *Entry) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.w.Write(e.Line)
+	return
+}
+
+// Close closes the writer if it is an io.Closer.
+func (s *WriterSink) Close(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
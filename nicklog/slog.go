@@ -0,0 +1,184 @@
+package nicklog
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+type SlogHandlerOptions struct {
+	// Level is the minimum slog level handled. If nil the logger's own level is used.
+	Level slog.Leveler
+
+	// MailLevel, if set, becomes the mail alert threshold of the logger.
+	MailLevel slog.Leveler
+}
+
+// SlogHandler is a slog.Handler writing through Logger, so slog records
+// get the same rotation, archive directory and mail alerts.
+type SlogHandler struct {
+	l      *Logger
+	level  slog.Leveler
+	fields []Field
+	group  string
+}
+
+func NewSlogHandler(l *Logger, opts *SlogHandlerOptions) *SlogHandler {
+
+	h := &SlogHandler{l: l}
+
+	if opts != nil {
+		h.level = opts.Level
+		if opts.MailLevel != nil {
+			l.SetMailLevel(LevelFromSlog(opts.MailLevel.Level()))
+		}
+	}
+
+	return h
+}
+
+// LevelFromSlog maps slog levels to nicklog levels. Anything above
+// slog.LevelError (e.g. slog.LevelError+4) is LevelFatal.
+func LevelFromSlog(lv slog.Level) Level {
+	switch {
+	case lv < slog.LevelInfo:
+		return LevelDebug
+	case lv < slog.LevelWarn:
+		return LevelInfo
+	case lv < slog.LevelError:
+		return LevelWarn
+	case lv == slog.LevelError:
+		return LevelError
+	default:
+		return LevelFatal
+	}
+}
+
+// SlogLevel is the reverse of LevelFromSlog.
+func (lv Level) SlogLevel() slog.Level {
+	switch lv {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelError + 4
+	}
+}
+
+func (h *SlogHandler) Enabled(ctx context.Context, lv slog.Level) bool {
+	if h.level != nil {
+		return lv >= h.level.Level()
+	}
+	return h.l.Enabled(LevelFromSlog(lv))
+}
+
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+
+	if !h.l.sample(LevelFromSlog(r.Level), r.Message) {
+		return nil
+	}
+
+	// fields stored in ctx by NewContext go first
+	ctxFields := FieldsFromContext(ctx)
+
+	fields := make([]Field, 0, len(ctxFields)+len(h.fields)+r.NumAttrs())
+	fields = append(fields, ctxFields...)
+	fields = append(fields, h.fields...)
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendAttr(fields, h.group, a)
+		return true
+	})
+
+	e := Entry{
+		Time:   r.Time,
+		Level:  LevelFromSlog(r.Level),
+		Msg:    r.Message,
+		Fields: fields,
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	h.l.annotate(&e, 1, r.PC)
+
+	h.l.writeEntry(&e)
+
+	return nil
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+
+	if len(attrs) == 0 {
+		return h
+	}
+
+	h2 := *h
+	h2.fields = make([]Field, 0, len(h.fields)+len(attrs))
+	h2.fields = append(h2.fields, h.fields...)
+	for i := 0; i < len(attrs); i++ {
+		h2.fields = appendAttr(h2.fields, h.group, attrs[i])
+	}
+
+	return &h2
+}
+
+// WithGroup qualifies the keys of all following attributes as "group.key".
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+
+	if len(name) == 0 {
+		return h
+	}
+
+	h2 := *h
+	h2.group = h.group + name + "."
+
+	return &h2
+}
+
+func appendAttr(fields []Field, prefix string, a slog.Attr) []Field {
+
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+
+	key := prefix + a.Key
+	v := a.Value
+
+	switch v.Kind() {
+	case slog.KindGroup:
+		attrs := v.Group()
+		if len(a.Key) > 0 {
+			prefix = key + "."
+		}
+		for i := 0; i < len(attrs); i++ {
+			fields = appendAttr(fields, prefix, attrs[i])
+		}
+		return fields
+	case slog.KindString:
+		return append(fields, String(key, v.String()))
+	case slog.KindInt64:
+		return append(fields, Int64(key, v.Int64()))
+	case slog.KindUint64:
+		return append(fields, Uint64(key, v.Uint64()))
+	case slog.KindFloat64:
+		return append(fields, Float64(key, v.Float64()))
+	case slog.KindBool:
+		return append(fields, Bool(key, v.Bool()))
+	case slog.KindDuration:
+		return append(fields, Duration(key, v.Duration()))
+	case slog.KindTime:
+		return append(fields, Time(key, v.Time()))
+	default:
+		if err, ok := v.Any().(error); ok {
+			return append(fields, Field{Key: key, typ: fieldError, any: err})
+		}
+		return append(fields, Any(key, v.Any()))
+	}
+}
@@ -0,0 +1,298 @@
+// Package smtptest provides an in-process SMTP server that records
+// delivered messages, for testing the nicklog mail path without a real relay.
+package smtptest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	From string
+	To   []string
+	Data []byte
+	Auth string
+}
+
+type Server struct {
+	Addr string
+	Host string
+	Port int
+
+	ln     net.Listener
+	lock   sync.Mutex
+	msgs   []Message
+	fail   int
+	notify chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewServer starts a server on a random port of 127.0.0.1. It panics
+// if it can't listen, like httptest.NewServer.
+func NewServer() *Server {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("smtptest: failed to listen: " + err.Error())
+	}
+
+	addr := ln.Addr().(*net.TCPAddr)
+
+	s := &Server{
+		Addr:   ln.Addr().String(),
+		Host:   addr.IP.String(),
+		Port:   addr.Port,
+		ln:     ln,
+		notify: make(chan struct{}, 1),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+// Close stops listening and waits for open sessions to finish.
+func (s *Server) Close() {
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+// Messages returns a copy of the delivered messages.
+func (s *Server) Messages() []Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Message(nil), s.msgs...)
+}
+
+func (s *Server) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.msgs = nil
+	s.fail = 0
+}
+
+// FailNext makes the next n transactions fail with 554 after DATA.
+func (s *Server) FailNext(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fail = n
+}
+
+// Wait waits until at least n messages are delivered or timeout passes.
+func (s *Server) Wait(n int, timeout time.Duration) ([]Message, bool) {
+
+	deadline := time.After(timeout)
+	for {
+		msgs := s.Messages()
+		if len(msgs) >= n {
+			return msgs, true
+		}
+		select {
+		case <-s.notify:
+		case <-deadline:
+			return msgs, false
+		}
+	}
+}
+
+func (s *Server) serve() {
+
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+
+	defer s.wg.Done()
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	reply := func(lines ...string) bool {
+		for i := 0; i < len(lines); i++ {
+			w.WriteString(lines[i])
+			w.WriteString("\r\n")
+		}
+		return w.Flush() == nil
+	}
+
+	readLine := func() (string, bool) {
+		conn.SetReadDeadline(time.Now().Add(time.Minute))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", false
+		}
+		return strings.TrimRight(line, "\r\n"), true
+	}
+
+	if !reply("220 smtptest ESMTP") {
+		return
+	}
+
+	var msg Message
+	for {
+		line, ok := readLine()
+		if !ok {
+			return
+		}
+
+		cmd := strings.ToUpper(line)
+		arg := ""
+		if i := strings.IndexByte(line, ' '); i > 0 {
+			cmd = strings.ToUpper(line[:i])
+			arg = strings.TrimSpace(line[i+1:])
+		}
+
+		switch cmd {
+		case "EHLO":
+			ok = reply("250-smtptest", "250-8BITMIME", "250 AUTH PLAIN LOGIN")
+		case "HELO":
+			ok = reply("250 smtptest")
+		case "AUTH":
+			msg.Auth, ok = s.auth(arg, reply, readLine)
+		case "MAIL":
+			msg.From = trimAddr(arg)
+			msg.To = nil
+			ok = reply("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, trimAddr(arg))
+			ok = reply("250 OK")
+		case "DATA":
+			if !reply("354 End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			var data bytes.Buffer
+			for {
+				l, ok := readLine()
+				if !ok {
+					return
+				}
+				if l == "." {
+					break
+				}
+				// dot-stuffing
+				if strings.HasPrefix(l, ".") {
+					l = l[1:]
+				}
+				data.WriteString(l)
+				data.WriteString("\r\n")
+			}
+			msg.Data = data.Bytes()
+			if s.deliver(msg) {
+				ok = reply("250 OK")
+			} else {
+				ok = reply("554 Transaction failed")
+			}
+		case "RSET":
+			msg = Message{Auth: msg.Auth}
+			ok = reply("250 OK")
+		case "NOOP":
+			ok = reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			ok = reply("502 Command not implemented")
+		}
+
+		if !ok {
+			return
+		}
+	}
+}
+
+// auth accepts any credentials and returns the user name.
+func (s *Server) auth(arg string, reply func(...string) bool, readLine func() (string, bool)) (string, bool) {
+
+	parts := strings.Fields(arg)
+	if len(parts) == 0 {
+		return "", reply("501 Syntax error")
+	}
+
+	switch strings.ToUpper(parts[0]) {
+	case "PLAIN":
+		resp := ""
+		if len(parts) > 1 {
+			resp = parts[1]
+		} else {
+			if !reply("334 ") {
+				return "", false
+			}
+			var ok bool
+			if resp, ok = readLine(); !ok {
+				return "", false
+			}
+		}
+		b, err := base64.StdEncoding.DecodeString(resp)
+		if err != nil {
+			return "", reply("501 Bad encoding")
+		}
+		fields := bytes.Split(b, []byte{0})
+		if len(fields) != 3 {
+			return "", reply("501 Bad credentials")
+		}
+		return string(fields[1]), reply("235 Authentication successful")
+	case "LOGIN":
+		if !reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:"))) {
+			return "", false
+		}
+		user, ok := readLine()
+		if !ok {
+			return "", false
+		}
+		if !reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:"))) {
+			return "", false
+		}
+		if _, ok = readLine(); !ok {
+			return "", false
+		}
+		b, _ := base64.StdEncoding.DecodeString(user)
+		return string(b), reply("235 Authentication successful")
+	default:
+		return "", reply("504 Unrecognized authentication type")
+	}
+}
+
+func (s *Server) deliver(msg Message) bool {
+
+	s.lock.Lock()
+	if s.fail > 0 {
+		s.fail--
+		s.lock.Unlock()
+		return false
+	}
+	s.msgs = append(s.msgs, msg)
+	s.lock.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+func trimAddr(arg string) string {
+	if i := strings.IndexByte(arg, ':'); i >= 0 {
+		arg = arg[i+1:]
+	}
+	if i := strings.IndexByte(arg, ' '); i >= 0 {
+		arg = arg[:i]
+	}
+	return strings.Trim(arg, "<>")
+}
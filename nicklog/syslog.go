@@ -0,0 +1,378 @@
+package nicklog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	cDefSyslogBufSize  = 1000
+	cDefSyslogTimeout  = 10 * time.Second
+	cSyslogRetryMin    = 1 * time.Second
+	cSyslogRetryMax    = 30 * time.Second
+	cSyslogTimeFormat  = "2006-01-02T15:04:05.000000Z07:00"
+	cSyslogDefaultPath = "/dev/log"
+)
+
+type Facility int
+
+const (
+	FacilityKern   Facility = 0
+	FacilityUser   Facility = 1
+	FacilityDaemon Facility = 3
+	FacilityLocal0 Facility = 16
+	FacilityLocal1 Facility = 17
+	FacilityLocal2 Facility = 18
+	FacilityLocal3 Facility = 19
+	FacilityLocal4 Facility = 20
+	FacilityLocal5 Facility = 21
+	FacilityLocal6 Facility = 22
+	FacilityLocal7 Facility = 23
+)
+
+// severity maps a level to the RFC 5424 severity.
+func (l Level) severity() int {
+	switch {
+	case l <= LevelDebug:
+		return 7 // debug
+	case l == LevelInfo:
+		return 6 // informational
+	case l == LevelWarn:
+		return 4 // warning
+	case l == LevelError:
+		return 3 // error
+	default:
+		return 2 // critical
+	}
+}
+
+type SyslogConfig struct {
+	// Network is "udp", "tcp" or "unix", an empty Network and Addr is the local /dev/log.
+	Network  string
+	Addr     string
+	Facility Facility
+	Level    Level
+	// AppName and Hostname default to the program name and os.Hostname.
+	AppName  string
+	Hostname string
+	// BufferSize is the number of entries kept while the daemon is unreachable,
+	// the oldest are dropped first.
+	BufferSize int
+	Timeout    time.Duration
+}
+
+// SyslogSink sends entries as RFC 5424 messages. TCP and stream unix sockets
+// use octet counting framing (RFC 6587). Entries are queued and sent by
+// a background goroutine, which reconnects with backoff when the daemon restarts.
+type SyslogSink struct {
+	network  string
+	addr     string
+	facility Facility
+	appName  string
+	hostname string
+	procID   string
+	bufSize  int
+	timeout  time.Duration
+	level    int32
+
+	lock    sync.Mutex
+	queue   [][]byte
+	dropped int
+
+	conn   net.Conn
+	stream bool
+
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func NewSyslogSink(cfg SyslogConfig) (s *SyslogSink, err error) {
+
+	switch cfg.Network {
+	case "":
+		if len(cfg.Addr) > 0 {
+			return nil, errors.New("Syslog network is not set")
+		}
+		cfg.Network = "unix"
+		cfg.Addr = cSyslogDefaultPath
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return nil, errors.New("Unsupported syslog network: " + cfg.Network)
+	}
+
+	if cfg.Facility < FacilityKern || cfg.Facility > FacilityLocal7 {
+		return nil, errors.New("Invalid syslog facility: " + strconv.Itoa(int(cfg.Facility)))
+	}
+
+	if len(cfg.AppName) == 0 {
+		cfg.AppName = filepath.Base(os.Args[0])
+	}
+
+	if len(cfg.Hostname) == 0 {
+		if cfg.Hostname, err = os.Hostname(); err != nil {
+			cfg.Hostname = "-"
+		}
+	}
+
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = cDefSyslogBufSize
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = cDefSyslogTimeout
+	}
+
+	s = &SyslogSink{
+		network:  cfg.Network,
+		addr:     cfg.Addr,
+		facility: cfg.Facility,
+		appName:  syslogName(cfg.AppName, 48),
+		hostname: syslogName(cfg.Hostname, 255),
+		procID:   strconv.Itoa(os.Getpid()),
+		bufSize:  cfg.BufferSize,
+		timeout:  cfg.Timeout,
+		level:    int32(cfg.Level),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	// the daemon may be down right now, run reconnects later
+	if err := s.connect(); err != nil {
+		log.Println(err.Error())
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+// syslogName keeps printable US-ASCII as RFC 5424 requires for header fields.
+func syslogName(name string, max int) string {
+	b := make([]byte, 0, len(name))
+	for i := 0; i < len(name) && len(b) < max; i++ {
+		if name[i] > 32 && name[i] < 127 {
+			b = append(b, name[i])
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+func (s *SyslogSink) SetLevel(level Level) {
+	atomic.StoreInt32(&s.level, int32(level))
+}
+
+func (s *SyslogSink) Enabled(level Level) bool {
+	return level >= Level(atomic.LoadInt32(&s.level))
+}
+
+// WriteEntry queues the entry, it never blocks on the network.
+func (s *SyslogSink) WriteEntry(e *Entry) error {
+
+	msg := s.format(e.Time, e.Level, s.appendMsg(nil, e))
+
+	s.lock.Lock()
+	if len(s.queue) >= s.bufSize {
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.queue = append(s.queue, msg)
+	s.lock.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// appendMsg is the first line of e.Line without the timestamp and level,
+// they are in the header already. JSON lines are sent whole. The fields are
+// not encoded again, in async mode the caller may be changing them.
+func (s *SyslogSink) appendMsg(dst []byte, e *Entry) []byte {
+
+	line := e.Line
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+
+	if len(line) >= len(cLineTimeFormat) {
+		if _, err := time.Parse(cLineTimeFormat, string(line[:len(cLineTimeFormat)])); err == nil {
+			line = bytes.TrimPrefix(line[len(cLineTimeFormat):], []byte(" "))
+			level := e.Level.String()
+			if len(line) > len(level) && string(line[:len(level)]) == level && line[len(level)] == ' ' {
+				line = line[len(level)+1:]
+			}
+		}
+	}
+
+	return append(dst, line...)
+}
+
+// format builds "<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG".
+func (s *SyslogSink) format(t time.Time, level Level, msg []byte) []byte {
+
+	b := make([]byte, 0, 64+len(s.hostname)+len(s.appName)+len(msg))
+
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(int(s.facility)*8+level.severity()), 10)
+	b = append(b, ">1 "...)
+	b = t.AppendFormat(b, cSyslogTimeFormat)
+	b = append(b, ' ')
+	b = append(b, s.hostname...)
+	b = append(b, ' ')
+	b = append(b, s.appName...)
+	b = append(b, ' ')
+	b = append(b, s.procID...)
+	b = append(b, " - - "...)
+	b = append(b, msg...)
+
+	return b
+}
+
+func (s *SyslogSink) connect() (err error) {
+
+	dialer := &net.Dialer{Timeout: s.timeout}
+
+	if s.network == "unix" {
+		// the local daemon listens on a datagram socket as a rule
+		if s.conn, err = dialer.Dial("unixgram", s.addr); err == nil {
+			s.stream = false
+			return nil
+		}
+		s.conn, err = dialer.Dial("unix", s.addr)
+		s.stream = true
+		return
+	}
+
+	s.conn, err = dialer.Dial(s.network, s.addr)
+	s.stream = s.network[:3] == "tcp"
+	return
+}
+
+func (s *SyslogSink) send(msg []byte) (err error) {
+
+	if s.stream {
+		frame := make([]byte, 0, len(msg)+8)
+		frame = strconv.AppendInt(frame, int64(len(msg)), 10)
+		frame = append(frame, ' ')
+		msg = append(frame, msg...)
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	_, err = s.conn.Write(msg)
+
+	return
+}
+
+// flush sends the queue, on error the connection is dropped
+// and the unsent entries stay queued.
+func (s *SyslogSink) flush() (err error) {
+
+	for {
+		if s.conn == nil {
+			if err = s.connect(); err != nil {
+				return
+			}
+		}
+
+		s.lock.Lock()
+		if s.dropped > 0 {
+			note := "syslog: " + strconv.Itoa(s.dropped) + " entries were dropped, the daemon was unreachable or too slow"
+			s.queue = append([][]byte{s.format(time.Now(), LevelWarn, []byte(note))}, s.queue...)
+			s.dropped = 0
+		}
+		if len(s.queue) == 0 {
+			s.lock.Unlock()
+			return nil
+		}
+		msg := s.queue[0]
+		s.lock.Unlock()
+
+		if err = s.send(msg); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return
+		}
+
+		s.lock.Lock()
+		// the head may have been dropped by WriteEntry in the meantime
+		if len(s.queue) > 0 && &s.queue[0][0] == &msg[0] {
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+		}
+		s.lock.Unlock()
+	}
+}
+
+func (s *SyslogSink) run() {
+
+	defer close(s.done)
+
+	retry := time.Duration(0)
+	var timer <-chan time.Time
+
+	for {
+		select {
+		case <-s.stop:
+			s.flush()
+			if s.conn != nil {
+				s.conn.Close()
+				s.conn = nil
+			}
+			return
+		case <-s.wake:
+			if retry > 0 {
+				// wait for the reconnect timer
+				continue
+			}
+		case <-timer:
+			timer = nil
+		}
+
+		if err := s.flush(); err != nil {
+			if retry == 0 {
+				log.Println(err.Error())
+				retry = cSyslogRetryMin
+			} else if retry *= 2; retry > cSyslogRetryMax {
+				retry = cSyslogRetryMax
+			}
+			timer = time.After(retry)
+			continue
+		}
+
+		retry = 0
+	}
+}
+
+// Close sends what is queued and closes the connection. Entries still queued
+// when ctx is done are lost.
+func (s *SyslogSink) Close(ctx context.Context) error {
+
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,73 @@
+package nicklog
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogSinkAsync(t *testing.T) {
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	sink, err := NewSyslogSink(SyslogConfig{
+		Network:  "udp",
+		Addr:     pc.LocalAddr().String(),
+		Facility: FacilityLocal0,
+		AppName:  "app",
+		Hostname: "host",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := NewLoggerFromConfig(Config{
+		Dir:      t.TempDir(),
+		FileName: "app.log",
+		MaxSize:  1024,
+		MaxFiles: 2,
+		Async:    true,
+		Sinks:    []Sink{sink},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the map is changed while the entry may still be queued
+	m := map[string]int{"a": 1}
+	l.Infow("hello", Any("m", m), Int("n", 2))
+	m["b"] = 2
+	l.Println("raw line")
+	l.Flush()
+
+	want := []string{
+		"<134>1 ",
+		" host app ",
+		" - - hello m=map[a:1] n=2",
+		" - - raw line",
+	}
+
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	var got []string
+	buf := make([]byte, 4096)
+	for len(got) < 2 {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(buf[:n]))
+	}
+
+	if !strings.HasPrefix(got[0], want[0]) || !strings.Contains(got[0], want[1]) || !strings.HasSuffix(got[0], want[2]) {
+		t.Errorf("got %q", got[0])
+	}
+	if !strings.HasSuffix(got[1], want[3]) {
+		t.Errorf("got %q", got[1])
+	}
+}
@@ -0,0 +1,181 @@
+package nicklog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const cDefWebhookTimeout = 30 * time.Second
+
+// Webhook describes an HTTP endpoint for alerts. The payload is rendered
+// from Template with *WebhookData and posted as application/json.
+type Webhook struct {
+	URL string
+	// Auth is sent as the Authorization header, e.g. "Bearer <token>".
+	Auth   string
+	Header http.Header
+	// Template is nil for DefaultWebhookTemplate. The "json" function
+	// quotes a value, e.g. {{json .Text}}.
+	Template *template.Template
+	Timeout  time.Duration
+	Client   *http.Client
+}
+
+type WebhookData struct {
+	Subject string
+	Time    time.Time
+	Note    string
+	Lines   []string
+	// Text is Note and Lines in one string.
+	Text string
+}
+
+// DefaultWebhookTemplate is understood by Slack and Mattermost incoming webhooks.
+var DefaultWebhookTemplate = NewWebhookTemplate(`{"text":{{json .Text}}}`)
+
+// NewWebhookTemplate parses a payload template with the "json" function. It panics on error.
+func NewWebhookTemplate(text string) *template.Template {
+	return template.Must(template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text))
+}
+
+// WebhookSender spools entries to disk exactly like MailSender and posts
+// each batch to a webhook. The level, period, retry and spool settings
+// are those of the embedded MailSender, the mail-only ones have no effect.
+type WebhookSender struct {
+	*MailSender
+	hook    Webhook
+	subject string
+}
+
+func NewWebhookSender(prefix string, hook Webhook, subject string, dir string, maxMsgSize int, sendMsgPeriod time.Duration) (w *WebhookSender, err error) {
+
+	if len(hook.URL) == 0 {
+		return nil, errors.New("Webhook URL is not set")
+	}
+
+	if hook.Template == nil {
+		hook.Template = DefaultWebhookTemplate
+	}
+
+	if hook.Client == nil {
+		timeout := hook.Timeout
+		if timeout <= 0 {
+			timeout = cDefWebhookTimeout
+		}
+		hook.Client = &http.Client{Timeout: timeout}
+	}
+
+	s, err := newSpool(prefix, dir, maxMsgSize, sendMsgPeriod)
+	if err != nil {
+		return
+	}
+
+	w = &WebhookSender{
+		MailSender: s,
+		hook:       hook,
+		subject:    subject,
+	}
+	s.deliver = w.post
+
+	s.start()
+
+	return
+}
+
+// post sends the files of one batch in a single request. Any status
+// but 2xx is an error, the batch is then retried by the spool.
+func (w *WebhookSender) post(files []string) (err error) {
+
+	w.createLast()
+	defer w.delLast()
+
+	data := &WebhookData{
+		Subject: w.subject,
+		Time:    time.Now().UTC(),
+		Note:    strings.TrimSpace(w.sendNote()),
+	}
+
+	for i := 0; i < len(files); i++ {
+		b, err := ioutil.ReadFile(filepath.Join(w.outDir, files[i]))
+		if err != nil {
+			return err
+		}
+		data.Lines = append(data.Lines, strings.Split(strings.TrimRight(string(b), "\n"), "\n")...)
+	}
+
+	data.Text = strings.Join(data.Lines, "\n")
+	if len(data.Note) > 0 {
+		data.Text = data.Note + "\n\n" + data.Text
+	}
+
+	var body bytes.Buffer
+	if err = w.hook.Template.Execute(&body, data); err != nil {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.hook.URL, &body)
+	if err != nil {
+		return
+	}
+
+	for k, v := range w.hook.Header {
+		req.Header[k] = v
+	}
+	if len(req.Header.Get("Content-Type")) == 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(w.hook.Auth) > 0 {
+		req.Header.Set("Authorization", w.hook.Auth)
+	}
+
+	resp, err := w.hook.Client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("Webhook " + w.hook.URL + " returned " + strconv.Itoa(resp.StatusCode) + ": " + strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// initWebhook creates the WebhookSender of Config.Webhook, spooled in "webhook".
+func (l *Logger) initWebhook(cfg *Config, prefix string) (err error) {
+
+	if cfg.Webhook == nil {
+		return nil
+	}
+
+	w, err := NewWebhookSender(prefix, *cfg.Webhook, cfg.MailSubj, filepath.Join(cfg.Dir, "webhook"), cfg.MaxMsgSize, cfg.SendMsgPeriod)
+	if err != nil {
+		return
+	}
+
+	w.SetLevel(cfg.WebhookLevel)
+	w.SetSendPeriod(cfg.MailSendPeriod)
+	w.SetMaxPerHour(cfg.MailMaxPerHour)
+	w.SetRetryPolicy(cfg.MailMaxAttempts, cfg.MailMaxAge)
+	w.SetMaxSpoolSize(cfg.MailMaxSpoolSize)
+
+	l.sinks = append(l.sinks, w)
+
+	return nil
+}
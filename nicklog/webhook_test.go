@@ -0,0 +1,94 @@
+package nicklog
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type hookRequest struct {
+	auth string
+	body []byte
+}
+
+func TestWebhookSender(t *testing.T) {
+
+	var lock sync.Mutex
+	var reqs []hookRequest
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		lock.Lock()
+		reqs = append(reqs, hookRequest{auth: r.Header.Get("Authorization"), body: body})
+		n := len(reqs)
+		lock.Unlock()
+
+		// the first post fails
+		if n == 1 {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	w, err := NewWebhookSender("app", Webhook{URL: srv.URL, Auth: "Bearer secret"}, "alert", dir, 1024*1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetSendPeriod(time.Hour)
+	defer func() {
+		close(w.stop)
+		w.wg.Wait()
+	}()
+
+	spool(t, dir, 1)
+
+	w.sendLock.Lock()
+	defer w.sendLock.Unlock()
+
+	if _, err := w.sendOut(true, false); err == nil {
+		t.Fatal("503 reply is not an error")
+	}
+	if st := w.retry["app1000.txt"]; st == nil || st.attempts != 1 {
+		t.Fatalf("failed post not scheduled for a retry: %+v", st)
+	}
+	if n := outFiles(t, dir); n != 1 {
+		t.Fatalf("%d files in out after the failure, want 1", n)
+	}
+
+	// not before the retry delay
+	if sent, _ := w.sendOut(false, false); sent {
+		t.Fatal("retried before the delay")
+	}
+
+	if sent, err := w.sendOut(true, false); err != nil || !sent {
+		t.Fatalf("retry: sent %v, err %v", sent, err)
+	}
+	if n := outFiles(t, dir); n != 0 {
+		t.Errorf("%d files left in out, want 0", n)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests, want 2", len(reqs))
+	}
+	for i := 0; i < len(reqs); i++ {
+		if reqs[i].auth != "Bearer secret" {
+			t.Errorf("Authorization %q", reqs[i].auth)
+		}
+		var payload map[string]string
+		if err := json.Unmarshal(reqs[i].body, &payload); err != nil {
+			t.Fatalf("payload %s: %v", reqs[i].body, err)
+		}
+		if len(payload) != 1 || payload["text"] != "2024-01-01 00:00:00 ERROR batch 0" {
+			t.Errorf("payload %s", reqs[i].body)
+		}
+	}
+}